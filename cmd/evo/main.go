@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/c-bata/go-prompt"
@@ -26,22 +29,50 @@ import (
 const (
 	defaultRegisters     = 8
 	defaultMaxIterations = 100
+	defaultHistory       = 1000
+	defaultTrials        = 1000
 	defaultEncoding      = cli.EncodingEvo
 )
 
+// MachineArgs configures the VM.
+type MachineArgs struct {
+	Registers     uint     `help:"number of registers to allocate"`
+	MaxIterations uint     `help:"max iterations before halting"`
+	HaltOnFault   bool     `help:"halt at the first fault"`
+	CallArgs      int      `help:"number of values passed to a called subroutine"`
+	Saturate      bool     `help:"saturate arithmetic results instead of wrapping"`
+	Width         vm.Width `help:"bits in a value: 8, 16, 32 or 64"`
+	Gas           int      `help:"gas budget; meters execution when set"`
+	DetectLoops   bool     `help:"halt as soon as the machine state repeats"`
+	// Stepping backwards in the REPL also undoes changes to the code.
+	SelfModifying bool `help:"enable the opcodes that read and change the script's code"`
+	MaxCodeLen    int  `help:"length self-modifying scripts may grow to; 0 means no limit"`
+	// Timeout does not apply to runs started from the REPL.
+	Timeout time.Duration `help:"stop running a script after this long, e.g. 500ms"`
+}
+
 // Args ...
 type Args struct {
-	REPL          bool         `arg:"-i" help:"start in interactive mode"`
-	Registers     uint         `help:"number of registers to allocate"`
-	MaxIterations uint         `help:"max iterations before halting"`
-	Format        cli.Encoding `help:"input file format"`
-	Filename      string       `arg:"positional" help:"a script file to load"`
+	REPL bool `arg:"-i" help:"start in interactive mode"`
+	MachineArgs
+	History  int          `help:"number of states kept for stepping backwards"`
+	Format   cli.Encoding `help:"input file format"`
+	Resume   string       `help:"an image file (.toml or .json) to resume; the image holds its own script, so no script file may be given"`
+	Filename string       `arg:"positional" help:"a script file to load"`
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
 	args := Args{
-		Registers:     defaultRegisters,
-		MaxIterations: defaultMaxIterations,
+		MachineArgs: defaultMachineArgs(),
+		History:     defaultHistory,
 	}
 	arg.MustParse(&args)
 	if args.Filename == cli.StdioFilename && args.Format == cli.EncodingNone {
@@ -52,18 +83,79 @@ func main() {
 	}
 }
 
-func run(args *Args) error {
+// subcommands are invoked as "evo <name> [args...]".
+var subcommands = map[string]func(args []string) error{
+	"run":      runCommand,
+	"trace":    traceCommand,
+	"cfg":      cfgCommand,
+	"lint":     lintCommand,
+	"simplify": simplifyCommand,
+}
+
+// parseArgs parses the arguments of a subcommand into dest.
+func parseArgs(name string, args []string, dest interface{}) {
+	p, err := arg.NewParser(arg.Config{Program: "evo " + name}, dest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch err := p.Parse(args); err {
+	case nil:
+	case arg.ErrHelp:
+		p.WriteHelp(os.Stdout)
+		os.Exit(0)
+	default:
+		p.Fail(err.Error())
+	}
+}
+
+func defaultMachineArgs() MachineArgs {
+	return MachineArgs{
+		Registers:     defaultRegisters,
+		MaxIterations: defaultMaxIterations,
+	}
+}
+
+// newMachine returns an empty state and a runtime configured by args.
+func newMachine(args *MachineArgs) (vm.State, vm.Runtime) {
 	var (
 		state = vm.State{
 			Registers: make(vm.Register, args.Registers),
 		}
 		runtime = vm.Runtime{
-			Impl:  impl.Map,
-			Hooks: vm.RuntimeWithMaxIterations(args.MaxIterations),
+			Impl:          impl.Map,
+			Hooks:         vm.RuntimeWithMaxIterations(args.MaxIterations),
+			CallArgs:      args.CallArgs,
+			Width:         args.Width,
+			HaltOnFault:   args.HaltOnFault,
+			SelfModifying: args.SelfModifying,
+			MaxCodeLen:    args.MaxCodeLen,
 		}
 	)
+	if args.Saturate {
+		runtime.Overflow = vm.OverflowSaturate
+	}
+	if args.DetectLoops {
+		runtime.AddHook(vm.RuntimeWithCycleDetection())
+	}
+	if args.Gas > 0 {
+		runtime.Costs = vm.CostTable{}
+		state.Gas = args.Gas
+	}
+	return state, runtime
+}
+
+func run(args *Args) error {
+	if args.Resume != "" && args.Filename != "" {
+		return errors.New("a script file cannot be loaded when resuming an image")
+	}
+	state, runtime := newMachine(&args.MachineArgs)
 	var fileLoaded bool
-	if args.Filename != "" {
+	if args.Resume != "" {
+		fileLoaded = true
+		if err := loadImage(args.Resume, &state, &runtime); err != nil {
+			return err
+		}
+	} else if args.Filename != "" {
 		fileLoaded = true
 		code, err := cli.Load(args.Filename, args.Format)
 		if err != nil {
@@ -73,20 +165,14 @@ func run(args *Args) error {
 	}
 	runScriptAndExit := fileLoaded && !args.REPL
 	if runScriptAndExit {
-		result := runtime.Run(&state)
-		snap := state.Snapshot()
-		summary := map[string]interface{}{
-			"result": result,
-			"state":  snap,
-		}
-		return dumpTOML(summary)
+		return runAndDump(&state, &runtime, args.Timeout)
 	}
-	repl := newRepl(&state, &runtime)
+	repl := newRepl(&state, &runtime, args.History)
 	repl.Loop()
 	return nil
 }
 
-func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
+func newRepl(state *vm.State, runtime *vm.Runtime, history int) *skua.Repl {
 	const contextLines = 2
 	printList := func(start, iptr int, code []vm.Op) error {
 		for i, instr := range code {
@@ -98,16 +184,43 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 			fmt.Printf(
 				"%s %s %s\n",
 				aurora.Red(s),
-				aurora.Gray(12, fmt.Sprintf("%2d:", i+1)),
+				aurora.Gray(12, fmt.Sprintf("%2d:", i+start+1)),
 				line,
 			)
 		}
 		return nil
 	}
-	opCodeSuggestions := make([]prompt.Suggest, 0, len(vm.OpCodes))
-	for name, op := range human.OpCodes {
+	listContext := func() error {
+		start := state.Script.Iptr - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := state.Script.Iptr + contextLines + 1
+		if end > len(state.Script.Code) {
+			end = len(state.Script.Code)
+		}
+		if start > end {
+			start = end
+		}
+		return printList(start, state.Script.Iptr, state.Script.Code[start:end])
+	}
+	recorder := vm.NewRecorder(history)
+	recorder.Attach(runtime)
+	recorder.Reset(state)
+	debugger := vm.NewDebugger()
+	debugger.Attach(runtime)
+	debugger.Reset(state)
+	profiler := vm.NewProfiler()
+	profiler.Attach(runtime)
+	printIteration := func() error {
+		fmt.Printf("iteration: %d\n", recorder.Iteration())
+		return listContext()
+	}
+	opCodes := vm.RegisteredOps()
+	opCodeSuggestions := make([]prompt.Suggest, 0, len(opCodes))
+	for _, op := range opCodes {
 		opCodeSuggestions = append(opCodeSuggestions, prompt.Suggest{
-			Text:        strings.ToLower(name),
+			Text:        strings.ToLower(op.String()),
 			Description: op.String(),
 		})
 	}
@@ -132,17 +245,7 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 								Run:         func([]string) error { return printList(0, state.Script.Iptr, state.Script.Code) },
 							},
 						},
-						Run: func([]string) error {
-							start := state.Script.Iptr - contextLines
-							if start < 0 {
-								start = 0
-							}
-							end := state.Script.Iptr + contextLines + 1
-							if end > len(state.Script.Code) {
-								end = len(state.Script.Code)
-							}
-							return printList(start, state.Script.Iptr, state.Script.Code[start:end])
-						},
+						Run: func([]string) error { return listContext() },
 					},
 					"new": skua.Command{
 						Description: "write new script",
@@ -168,6 +271,9 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 								code = append(code, instr)
 							}
 							state.Script = vm.Script{Code: code}
+							recorder.Reset(state)
+							debugger.Reset(state)
+							profiler.Reset()
 							return nil
 						},
 					},
@@ -183,6 +289,9 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 								return err
 							}
 							state.Script = vm.Script{Code: code}
+							recorder.Reset(state)
+							debugger.Reset(state)
+							profiler.Reset()
 							return nil
 						},
 					},
@@ -193,7 +302,7 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 								Description: "output in EvoX format",
 								Run: func(args []string) error {
 									filename := firstString(args)
-									b, err := evox.Marshal(state.Script.Code)
+									b, err := evox.MarshalWidth(state.Script.Code, runtime.Width)
 									if err != nil {
 										return err
 									}
@@ -212,15 +321,55 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 					},
 					"run": skua.Command{
 						Description: "run script",
+						Subcommands: map[string]skua.Command{
+							"back": skua.Command{
+								Description: "step backwards until a breakpoint or the start of the history",
+								Run: func([]string) error {
+									for recorder.Back(state) {
+										debugger.Reset(state)
+										if p, ok := debugger.BreakpointAt(state); ok {
+											fmt.Println("stopped at " + p.String())
+											break
+										}
+									}
+									return printIteration()
+								},
+							},
+						},
 						Run: func([]string) error {
-							runtime.Run(state)
-							return nil
+							result := runtime.Run(state)
+							fmt.Printf("iterations: %d, faults: %d\n", result.Iterations, result.Faults)
+							printFault(result.Fault)
+							if loop := result.Loop; loop != nil {
+								fmt.Printf("loop: entered at iteration %d, line %d; length %d\n", loop.Start, loop.Iptr+1, loop.Length)
+							}
+							fmt.Println(debugger.Stopped(state, result))
+							return listContext()
 						},
 					},
 					"step": skua.Command{
 						Description: "step script",
+						Subcommands: map[string]skua.Command{
+							"back": skua.Command{
+								Description: "step backwards",
+								Run: func([]string) error {
+									if !recorder.Back(state) {
+										return fmt.Errorf("no history before iteration %d", recorder.Iteration())
+									}
+									debugger.Reset(state)
+									return printIteration()
+								},
+							},
+						},
 						Run: func([]string) error {
-							runtime.Step(state)
+							var result vm.RunResult
+							if runtime.BeforeStep(state, &result) {
+								runtime.Step(state, &result)
+							}
+							printFault(result.Fault)
+							if result.Interrupted {
+								fmt.Println(debugger.Stopped(state, result))
+							}
 							return nil
 						},
 					},
@@ -231,20 +380,170 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 							if err != nil {
 								return err
 							}
-							runtime.Exec(state, instr)
+							printFault(runtime.Exec(state, instr))
+							recorder.Record(state)
+							debugger.Reset(state)
 							return nil
 						},
 						AdditionalSuggestions: func() []prompt.Suggest { return opCodeSuggestions },
 					},
+					"goto": skua.Command{
+						Description: "move forwards or backwards to an iteration",
+						Run: func(args []string) error {
+							iteration, err := intArg(args, "script goto <iteration>")
+							if err != nil {
+								return err
+							}
+							err = recorder.Goto(runtime, state, iteration)
+							debugger.Reset(state)
+							if err != nil {
+								return err
+							}
+							return printIteration()
+						},
+					},
 					"reset": skua.Command{
 						Description: "reset instruction pointer",
 						Run: func([]string) error {
 							state.Script.Reset()
+							recorder.Reset(state)
+							debugger.Reset(state)
+							return nil
+						},
+					},
+				},
+			},
+			"session": skua.Command{
+				Description: "save or resume the script and machine state",
+				Subcommands: map[string]skua.Command{
+					"save": skua.Command{
+						Description: "save the session to an image file (.toml or .json)",
+						Run: func(args []string) error {
+							if len(args) == 0 {
+								return errors.New("usage: session save <file>")
+							}
+							return cli.SaveImage(args[0], cli.NewImage(state, runtime.Width))
+						},
+					},
+					"load": skua.Command{
+						Description: "resume a session from an image file (.toml or .json)",
+						Run: func(args []string) error {
+							if len(args) == 0 {
+								return errors.New("usage: session load <file>")
+							}
+							if err := loadImage(args[0], state, runtime); err != nil {
+								return err
+							}
+							recorder.Reset(state)
+							debugger.Reset(state)
+							profiler.Reset()
+							return listContext()
+						},
+					},
+				},
+			},
+			"profile": skua.Command{
+				Description: "print the script with the number of times each line ran",
+				Subcommands: map[string]skua.Command{
+					"ops": skua.Command{
+						Description: "print the number of times each opcode ran",
+						Run: func([]string) error {
+							printOpProfile(profiler)
+							return nil
+						},
+					},
+					"reset": skua.Command{
+						Description: "clear the counts",
+						Run: func([]string) error {
+							profiler.Reset()
+							return nil
+						},
+					},
+				},
+				Run: func([]string) error {
+					printProfile(os.Stdout, true, state.Script.Code, state.Script.Iptr, profiler)
+					return nil
+				},
+			},
+			"break": skua.Command{
+				Description: "set a breakpoint at a line, or list breakpoints and watchpoints",
+				Subcommands: map[string]skua.Command{
+					"label": skua.Command{
+						Description: "set a breakpoint at a label",
+						Run: func(args []string) error {
+							label, err := intArg(args, "break label <n>")
+							if err != nil {
+								return err
+							}
+							fmt.Println(debugger.BreakLabel(vm.Value(label)))
+							return nil
+						},
+					},
+				},
+				Run: func(args []string) error {
+					if len(args) == 0 {
+						for _, p := range debugger.Points() {
+							fmt.Println(p)
+						}
+						return nil
+					}
+					line, err := intArg(args, "break <line>")
+					if err != nil {
+						return err
+					}
+					fmt.Println(debugger.Break(line - 1))
+					return nil
+				},
+			},
+			"watch": skua.Command{
+				Description: "set a watchpoint",
+				Subcommands: map[string]skua.Command{
+					"reg": skua.Command{
+						Description: "watch a register",
+						Run: func(args []string) error {
+							i, err := intArg(args, "watch reg <i>")
+							if err != nil {
+								return err
+							}
+							fmt.Println(debugger.WatchRegister(i))
+							return nil
+						},
+					},
+					"stack": skua.Command{
+						Description: "watch the stack",
+						Run: func([]string) error {
+							fmt.Println(debugger.WatchStack())
 							return nil
 						},
 					},
 				},
 			},
+			"delete": skua.Command{
+				Description: "delete a breakpoint or watchpoint, or all of them",
+				Run: func(args []string) error {
+					if len(args) == 0 {
+						debugger.DeleteAll()
+						return nil
+					}
+					id, err := intArg(args, "delete [id]")
+					if err != nil {
+						return err
+					}
+					if !debugger.Delete(id) {
+						return fmt.Errorf("no breakpoint or watchpoint %d", id)
+					}
+					return nil
+				},
+			},
+			"continue": skua.Command{
+				Description: "run until a breakpoint or watchpoint",
+				Run: func([]string) error {
+					stop, result := debugger.Continue(runtime, state)
+					printFault(result.Fault)
+					fmt.Println(stop)
+					return listContext()
+				},
+			},
 			"dump": skua.Command{
 				Description: "inspect state",
 				Subcommands: map[string]skua.Command{
@@ -282,6 +581,86 @@ func newRepl(state *vm.State, runtime *vm.Runtime) *skua.Repl {
 	}
 }
 
+// loadImage replaces state with the session saved in filename, and sets the
+// runtime's width to the session's.
+func loadImage(filename string, state *vm.State, runtime *vm.Runtime) error {
+	img, err := cli.LoadImage(filename)
+	if err != nil {
+		return err
+	}
+	resumed, err := img.NewState()
+	if err != nil {
+		return err
+	}
+	*state = resumed
+	runtime.Width = img.Width
+	return nil
+}
+
+// printProfile writes code to w with the hit count of each line. Colors are
+// only used if color is set.
+func printProfile(w io.Writer, color bool, code []vm.Op, iptr int, profiler *vm.Profiler) {
+	au := aurora.NewAurora(color)
+	lines := profiler.Lines()
+	for i, instr := range code {
+		s := " "
+		if i == iptr {
+			s = ">"
+		}
+		var hits int
+		if i < len(lines) {
+			hits = lines[i]
+		}
+		count := au.Green(fmt.Sprintf("%6d", hits))
+		if hits == 0 {
+			count = au.Gray(12, fmt.Sprintf("%6s", "-"))
+		}
+		fmt.Fprintf(
+			w,
+			"%s %s %s %s\n",
+			au.Red(s),
+			count,
+			au.Gray(12, fmt.Sprintf("%2d:", i+1)),
+			human.EncodeLine(instr),
+		)
+	}
+	covered := profiler.Covered(len(code))
+	fmt.Fprintf(w, "coverage: %d/%d lines (%.0f%%)\n", covered, len(code), 100*profiler.Coverage(len(code)))
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printOpProfile prints the hit count of each opcode that ran.
+func printOpProfile(profiler *vm.Profiler) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"OpCode", "Hits"})
+	ops := profiler.Ops()
+	for _, op := range vm.RegisteredOps() {
+		if hits, ok := ops[op]; ok {
+			table.Append([]string{op.String(), strconv.Itoa(hits)})
+		}
+	}
+	table.Render()
+}
+
+func printFault(fault *vm.Fault) {
+	if fault == nil {
+		return
+	}
+	fmt.Println(aurora.Red("fault:"), fault.Error())
+}
+
+func intArg(args []string, usage string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("usage: " + usage)
+	}
+	return strconv.Atoi(args[0])
+}
+
 func firstString(args []string) string {
 	if len(args) == 0 {
 		return ""
@@ -328,6 +707,30 @@ func load(filename string) ([]vm.Op, error) {
 	return code, err
 }
 
+// runAndDump runs state to completion, or until the timeout if it is set,
+// and prints the result and final state.
+func runAndDump(state *vm.State, runtime *vm.Runtime, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result := runtime.RunContext(ctx, state)
+	snap := state.Snapshot()
+	summary := map[string]interface{}{
+		"result": result,
+		"state":  snap,
+	}
+	if err := dumpTOML(summary); err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return fmt.Errorf("run stopped after %d iterations: %w", result.Iterations, result.Err)
+	}
+	return nil
+}
+
 func dumpTOML(v interface{}) error {
 	b, err := toml.Marshal(v)
 	if err != nil {
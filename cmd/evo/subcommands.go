@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/logrusorgru/aurora"
+
+	"github.com/jncornett/beans-engine/evo/cli"
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/cfg"
+	human "github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+	"github.com/jncornett/beans-engine/evo/vm/lint"
+	"github.com/jncornett/beans-engine/evo/vm/optimize"
+)
+
+// RunArgs ...
+type RunArgs struct {
+	MachineArgs
+	Format   cli.Encoding `help:"input file format"`
+	Trace    string       `help:"write an execution trace to this file as JSON lines"`
+	Profile  bool         `help:"print the script with the number of times each line ran to stderr"`
+	Filename string       `arg:"positional,required" help:"a script file to run"`
+}
+
+func runCommand(argv []string) error {
+	args := RunArgs{MachineArgs: defaultMachineArgs()}
+	parseArgs("run", argv, &args)
+	if args.Filename == cli.StdioFilename && args.Format == cli.EncodingNone {
+		args.Format = defaultEncoding
+	}
+	code, err := cli.Load(args.Filename, args.Format)
+	if err != nil {
+		return err
+	}
+	state, runtime := newMachine(&args.MachineArgs)
+	state.Script = vm.Script{Code: code}
+	if args.Profile {
+		profiler := vm.NewProfiler()
+		profiler.Attach(&runtime)
+		// the profile goes to stderr, so the dump on stdout can be piped
+		defer printProfile(os.Stderr, isTerminal(os.Stderr), code, -1, profiler)
+	}
+	if args.Trace == "" {
+		return runAndDump(&state, &runtime, args.Timeout)
+	}
+	f, err := os.Create(args.Trace)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	tracer := vm.NewTracer(w)
+	tracer.Attach(&runtime)
+	tracer.Reset(&state)
+	// the trace is flushed even if the run fails, so it shows how far it got;
+	// the first error is reported
+	errs := []error{
+		runAndDump(&state, &runtime, args.Timeout),
+		tracer.Err(),
+		w.Flush(),
+		f.Close(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TraceArgs ...
+type TraceArgs struct {
+	Op       string `help:"only show instructions with this opcode"`
+	Line     int    `help:"only show instructions at this line"`
+	Register *int   `arg:"--reg" help:"only show instructions that change this register"`
+	Faults   bool   `help:"only show instructions that faulted"`
+	From     int    `help:"skip iterations before this one"`
+	To       int    `help:"stop after this iteration"`
+	JSON     bool   `help:"print records as JSON lines"`
+	Filename string `arg:"positional" help:"a trace written by evo run --trace; defaults to stdin"`
+}
+
+// match reports whether rec passes the filters.
+func (args *TraceArgs) match(rec *vm.TraceRecord) bool {
+	if args.Op != "" && !strings.EqualFold(args.Op, rec.Op) {
+		return false
+	}
+	if args.Line > 0 && args.Line != rec.Iptr+1 {
+		return false
+	}
+	if args.Register != nil {
+		if _, ok := rec.Registers[*args.Register]; !ok {
+			return false
+		}
+	}
+	if args.Faults && rec.Fault == "" {
+		return false
+	}
+	return rec.Iteration >= args.From
+}
+
+func traceCommand(argv []string) error {
+	var args TraceArgs
+	parseArgs("trace", argv, &args)
+	var r io.Reader = os.Stdin
+	if args.Filename != "" && args.Filename != cli.StdioFilename {
+		f, err := os.Open(args.Filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+	dec := vm.NewTraceDecoder(r)
+	for {
+		var rec vm.TraceRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if args.To > 0 && rec.Iteration > args.To {
+			return nil
+		}
+		if !args.match(&rec) {
+			continue
+		}
+		if args.JSON {
+			err = enc.Encode(rec)
+		} else {
+			_, err = fmt.Fprintln(w, formatTraceRecord(&rec))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// formatTraceRecord renders rec as a single line, numbering lines from 1 as
+// the REPL does.
+func formatTraceRecord(rec *vm.TraceRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%6d %4d: ", rec.Iteration, rec.Iptr+1)
+	if op, ok := vm.LookupOp(rec.Op); ok {
+		line := human.EncodeLine(vm.Op{Type: op, Arg: rec.Arg})
+		fmt.Fprintf(&b, "%-12s", strings.Replace(line, "\t", " ", -1))
+	} else {
+		fmt.Fprintf(&b, "%-12s", fmt.Sprintf("%s %d", rec.Op, rec.Arg))
+	}
+	fmt.Fprintf(&b, " depth=%d", rec.Depth)
+	if rec.Top != nil {
+		fmt.Fprintf(&b, " top=%d", *rec.Top)
+	}
+	if len(rec.Registers) > 0 {
+		indexes := make([]int, 0, len(rec.Registers))
+		for i := range rec.Registers {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			fmt.Fprintf(&b, " r%d=%d", i, rec.Registers[i])
+		}
+	}
+	if rec.Fault != "" {
+		fmt.Fprintf(&b, " %s", aurora.Red("fault="+rec.Fault))
+	}
+	return b.String()
+}
+
+// CfgArgs ...
+type CfgArgs struct {
+	Width    vm.Width     `help:"bits in a value (8, 16, 32 or 64), which bound indirect jumps"`
+	Format   cli.Encoding `help:"input file format"`
+	Filename string       `arg:"positional,required" help:"a script file to analyze"`
+}
+
+func cfgCommand(argv []string) error {
+	var args CfgArgs
+	parseArgs("cfg", argv, &args)
+	if args.Filename == cli.StdioFilename && args.Format == cli.EncodingNone {
+		args.Format = defaultEncoding
+	}
+	code, err := cli.Load(args.Filename, args.Format)
+	if err != nil {
+		return err
+	}
+	return cfg.Build(code, args.Width).WriteDOT(os.Stdout)
+}
+
+// LintArgs ...
+type LintArgs struct {
+	Registers uint         `help:"number of registers the scripts run with"`
+	CallArgs  int          `help:"number of values passed to a called subroutine"`
+	Width     vm.Width     `help:"bits in a value: 8, 16, 32 or 64"`
+	JSON      bool         `help:"print findings as JSON lines"`
+	Format    cli.Encoding `help:"input file format"`
+	Filenames []string     `arg:"positional,required" help:"script files to check"`
+}
+
+// LintFinding is a finding in a file.
+type LintFinding struct {
+	File string `json:"file"`
+	lint.Finding
+}
+
+func lintCommand(argv []string) error {
+	args := LintArgs{Registers: defaultRegisters}
+	parseArgs("lint", argv, &args)
+	enc := json.NewEncoder(os.Stdout)
+	var found int
+	for _, filename := range args.Filenames {
+		code, lines, err := loadLines(filename, args.Format)
+		if err != nil {
+			return err
+		}
+		findings := lint.Lint(code, lint.Options{
+			Registers: int(args.Registers),
+			CallArgs:  args.CallArgs,
+			Width:     args.Width,
+			Lines:     lines,
+		})
+		found += len(findings)
+		for _, f := range findings {
+			if args.JSON {
+				err = enc.Encode(LintFinding{File: filename, Finding: f})
+			} else {
+				_, err = fmt.Printf("%s:%v\n", filename, f)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if found > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// loadLines loads a script along with the source line of each instruction.
+// Lines are only known for the evo text format.
+func loadLines(filename string, e cli.Encoding) (code []vm.Op, lines []int, err error) {
+	if e == cli.EncodingNone {
+		e = cli.GuessEncoding(filename)
+	}
+	if filename == cli.StdioFilename && e == cli.EncodingNone {
+		e = defaultEncoding
+	}
+	if e != cli.EncodingEvo {
+		code, err = cli.Load(filename, e)
+		return code, nil, err
+	}
+	var b []byte
+	if filename == cli.StdioFilename {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return human.UnmarshalLines(b)
+}
+
+// SimplifyArgs ...
+type SimplifyArgs struct {
+	Registers     uint         `help:"number of registers the script runs with"`
+	CallArgs      int          `help:"number of values passed to a called subroutine"`
+	Saturate      bool         `help:"saturate arithmetic results instead of wrapping"`
+	Width         vm.Width     `help:"bits in a value: 8, 16, 32 or 64"`
+	MaxIterations uint         `help:"max iterations of each verification run"`
+	Trials        int          `help:"number of random register inputs to verify the result with"`
+	Seed          int64        `help:"seed for the random register inputs"`
+	Format        cli.Encoding `help:"input file format"`
+	Filename      string       `arg:"positional,required" help:"a script file to simplify"`
+}
+
+func simplifyCommand(argv []string) error {
+	args := SimplifyArgs{
+		Registers:     defaultRegisters,
+		MaxIterations: defaultMaxIterations,
+		Trials:        defaultTrials,
+		Seed:          1,
+	}
+	parseArgs("simplify", argv, &args)
+	if args.Filename == cli.StdioFilename && args.Format == cli.EncodingNone {
+		args.Format = defaultEncoding
+	}
+	code, err := cli.Load(args.Filename, args.Format)
+	if err != nil {
+		return err
+	}
+	runtime := vm.Runtime{
+		Impl:     impl.Map,
+		Hooks:    vm.RuntimeWithMaxIterations(args.MaxIterations),
+		CallArgs: args.CallArgs,
+		Width:    args.Width,
+	}
+	if args.Saturate {
+		runtime.Overflow = vm.OverflowSaturate
+	}
+	simplified := optimize.Simplify(code, optimize.Options{
+		Registers: int(args.Registers),
+		CallArgs:  args.CallArgs,
+		Overflow:  runtime.Overflow,
+		Width:     runtime.Width,
+	})
+	rng := rand.New(rand.NewSource(args.Seed))
+	if err := optimize.Verify(&runtime, code, simplified, int(args.Registers), args.Trials, rng); err != nil {
+		return fmt.Errorf("simplified script does not match the original: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d -> %d instructions\n", len(code), len(simplified))
+	return human.NewEncoder(os.Stdout).Encode(simplified)
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"os"
+	"os/signal"
 	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
@@ -26,15 +29,26 @@ const (
 	defaultReapRatio         = 0.5
 	defaultInputSize         = 8
 	defaultCodeSize          = 100
+	defaultFaultPenalty      = 0.1
+	defaultOverrunPenalty    = 1
+	defaultBreedAttempts     = 10
 )
 
 // Args ...
 type Args struct {
-	Size    int     `help:"population size"`
-	Target  float64 `help:"target cost"`
-	Max     int     `help:"max iterations"`
-	Timeout int     `help:"vm timeout in steps"`
-	Input   int     `help:"number of vm registers"`
+	Size        int           `help:"population size"`
+	Target      float64       `help:"target cost"`
+	Max         int           `help:"max iterations"`
+	Timeout     int           `help:"vm timeout in steps"`
+	Input       int           `help:"number of vm registers"`
+	Fault       float64       `help:"cost added per vm fault"`
+	Energy      int           `help:"vm energy budget per run; meters execution when set"`
+	Gas         float64       `help:"cost added per unit of energy used"`
+	DetectLoops bool          `help:"halt looping programs as soon as their state repeats instead of running them until the timeout"`
+	Coverage    bool          `help:"log which instructions the final population executes"`
+	Budget      time.Duration `help:"wall-clock limit for each vm run, e.g. 10ms"`
+	Overrun     float64       `help:"cost added per vm run that exceeds its budget"`
+	Width       vm.Width      `help:"bits in a vm value: 8, 16, 32 or 64"`
 }
 
 func main() {
@@ -43,53 +57,105 @@ func main() {
 		Max:     defaultMaxIterations,
 		Input:   defaultInputSize,
 		Timeout: defaultRuntimeIterations,
+		Fault:   defaultFaultPenalty,
+		Overrun: defaultOverrunPenalty,
 		Target:  1,
 	}
 	arg.MustParse(&args)
 	rand.Seed(time.Now().Unix())
-	if err := run(&args); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// stop evaluating on shutdown and print the best genome so far
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+	if err := run(ctx, &args); err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 	}
 }
 
-func run(args *Args) error {
+func run(ctx context.Context, args *Args) error {
 	// FIXME do some additional parameter valiation...
 	log.Printf("Args: %+v\n", args)
 	stepLogDebounce := debounceDuration(2 * time.Second)
-	var codes [][]vm.Op
+	var (
+		codes [][]vm.Op
+		// programs holds the compiled codes, in the same order
+		programs []*vm.Program
+		// ranked is set once a step has sorted codes by cost
+		ranked bool
+	)
 	sim := optima.Simulation{
 		Size:          args.Size,
 		TargetCost:    args.Target,
 		MaxIterations: args.Max,
 		ReapRatio:     defaultReapRatio,
 		OnStep: func(minCost float64, steps int) {
+			ranked = true
 			stepLogDebounce(func() {
 				log.Printf("Step: cost=%v, steps=%v, popSize=%v\n", minCost, steps, len(codes))
 			})
 		},
 	}
+	// children that can never write an output are bred again
+	viable := genome.Writes(args.Input, 0, 1, 2)
 	runtime := vm.Runtime{
-		Impl:  impl.Map,
-		Hooks: vm.RuntimeWithMaxIterations(uint(args.Timeout)),
+		Impl:          impl.Map,
+		MaxIterations: args.Timeout,
+		Width:         args.Width,
 	}
-	costFunc := CostFunc123(func(i int) []int8 {
+	if args.Energy > 0 {
+		runtime.Costs = vm.CostTable{}
+	}
+	compile := func(code []vm.Op) *vm.Program {
+		if !args.DetectLoops {
+			return runtime.Compile(code)
+		}
+		// programs run concurrently, so each needs its own loop detector
+		own := runtime
+		own.Hooks = nil
+		own.AddHook(runtime.Hooks).AddHook(vm.RuntimeWithCycleDetection())
+		return own.Compile(code)
+	}
+	// add compiles code once, when it joins the population
+	add := func(code []vm.Op) {
+		codes = append(codes, code)
+		programs = append(programs, compile(code))
+	}
+	// evaluate runs codes[i] until it ends, ctx is done or its budget is spent
+	evaluate := func(ctx context.Context, i int) ([]vm.Value, vm.RunResult) {
 		registers := make(vm.Register, args.Input)
-		state := &vm.State{Registers: registers}
+		state := &vm.State{Registers: registers, Gas: args.Energy}
 		state.Script.Code = codes[i]
-		_ = runtime.Run(state)
-		out := make([]int8, 3)
-		for i, v := range registers[:3] {
-			if i >= len(out) {
-				break
-			}
-			out[i] = int8(v)
+		runCtx := ctx
+		if args.Budget > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, args.Budget)
+			defer cancel()
 		}
-		return out
-	})
+		result := programs[i].RunContext(runCtx, state)
+		out := make([]vm.Value, 3)
+		copy(out, registers)
+		return out, result
+	}
 	pop := &optima.PopulationFuncs{
 		LenFunc: func() int { return len(codes) },
 		CostFunc: func(i int) float64 {
-			cost := costFunc(i)
+			if ctx.Err() != nil {
+				// the generation is abandoned, so its remaining runs are skipped
+				return math.Inf(1)
+			}
+			out, result := evaluate(ctx, i)
+			cost := Cost123(out)
+			if result.Err != nil {
+				// the run was cut short by its budget
+				cost += args.Overrun
+			}
+			cost += args.Fault * float64(result.Faults)
+			cost += args.Gas * float64(result.GasUsed)
 			cost += 0.1 * float64(len(codes[i]))
 			return cost
 		},
@@ -97,33 +163,98 @@ func run(args *Args) error {
 			if len(codes) == 0 {
 				// start from beginning
 				for i := 0; i < args.Size; i++ {
-					codes = append(codes, genome.SampleN(genome.Default, defaultCodeSize))
+					add(genome.Retry(defaultBreedAttempts, viable, func() []vm.Op {
+						return genome.SampleN(genome.Default, defaultCodeSize)
+					}))
 				}
 				return
 			}
 			bv := discrete.Bernoulli(0.8)
 			pv := discrete.Range(0, int64(len(codes)))
 			for i := 0; i < n; i++ {
-				var code []vm.Op
-				if bv.Sample() {
-					code = genome.Mutate(genome.DefaultChange, genome.Default, codes[int(pv.Sample())])
-				} else {
+				code := genome.Retry(defaultBreedAttempts, viable, func() []vm.Op {
+					if bv.Sample() {
+						return genome.Mutate(genome.DefaultChange, genome.Default, codes[int(pv.Sample())])
+					}
 					left := codes[int(pv.Sample())]
 					right := codes[int(pv.Sample())]
-					code = genome.Recombine(genome.DefaultRecombine, left, right)
-				}
-				codes = append(codes, code)
+					return genome.Recombine(genome.DefaultRecombine, left, right)
+				})
+				add(code)
 			}
 		},
-		ReapFunc: func(n int) { codes = codes[:len(codes)-n] },
-		SwapFunc: func(i, j int) { codes[i], codes[j] = codes[j], codes[i] },
+		ReapFunc: func(n int) {
+			codes = codes[:len(codes)-n]
+			programs = programs[:len(programs)-n]
+		},
+		SwapFunc: func(i, j int) {
+			codes[i], codes[j] = codes[j], codes[i]
+			programs[i], programs[j] = programs[j], programs[i]
+		},
+	}
+	cost, steps := sim.OptimizeContext(ctx, pop)
+	if err := ctx.Err(); err != nil {
+		log.Printf("Stopped: %v\n", err)
 	}
-	cost, steps := sim.Optimize(pop)
 	log.Printf("Done: cost=%v, steps=%v\n", cost, steps)
+	if args.Coverage {
+		profiler := vm.NewProfiler()
+		profiler.Attach(&runtime)
+		for i, code := range codes {
+			programs[i] = compile(code)
+		}
+		// the optimization may have been canceled, but the coverage runs
+		// should not be
+		logCoverage(codes, profiler, func(i int) { evaluate(context.Background(), i) })
+	}
+	if !ranked {
+		log.Printf("No step finished, so there is no best genome\n")
+		return nil
+	}
 	evo.NewEncoder(os.Stdout).Encode(codes[0])
 	return nil
 }
 
+// logCoverage runs every code with profiler attached and logs how much of
+// the population's code executes and which opcodes it spends its time on.
+func logCoverage(codes [][]vm.Op, profiler *vm.Profiler, evaluate func(i int)) {
+	total := vm.NewProfiler()
+	var genes, covered, dead int
+	for i, code := range codes {
+		profiler.Reset()
+		evaluate(i)
+		n := profiler.Covered(len(code))
+		genes += len(code)
+		covered += n
+		if n == 0 {
+			dead++
+		}
+		total.Merge(profiler)
+	}
+	if genes == 0 {
+		return
+	}
+	log.Printf("Coverage: %d/%d genes executed (%.1f%%), %d programs executed nothing\n",
+		covered, genes, 100*float64(covered)/float64(genes), dead)
+	ops := total.Ops()
+	var hits int
+	for _, n := range ops {
+		hits += n
+	}
+	type opHits struct {
+		op vm.OpCode
+		n  int
+	}
+	var sorted []opHits
+	for op, n := range ops {
+		sorted = append(sorted, opHits{op, n})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].n > sorted[j].n })
+	for _, h := range sorted {
+		log.Printf("Coverage: %-8v %8d (%.1f%%)\n", h.op, h.n, 100*float64(h.n)/float64(hits))
+	}
+}
+
 func randomRegisters(n int) vm.Register {
 	reg := make(vm.Register, n)
 	for i := 0; i < n; i++ {
@@ -182,14 +313,18 @@ func debounceDuration(d time.Duration) func(func()) {
 }
 
 // CostFunc123 ...
-func CostFunc123(computeFn func(int) []int8) func(int) float64 {
+func CostFunc123(computeFn func(int) []vm.Value) func(int) float64 {
 	return func(i int) float64 {
-		out := computeFn(i)
-		for len(out) < 3 {
-			out = append(out, math.MaxInt8-1)
-		}
-		return math.Abs(float64(out[0])-1) + math.Abs(float64(out[1])-2) + math.Abs(float64(out[2]-3))
+		return Cost123(computeFn(i))
+	}
+}
+
+// Cost123 ...
+func Cost123(out []vm.Value) float64 {
+	for len(out) < 3 {
+		out = append(out, math.MaxInt8-1)
 	}
+	return math.Abs(float64(out[0])-1) + math.Abs(float64(out[1])-2) + math.Abs(float64(out[2])-3)
 }
 
 // CostFuncSortedList ...
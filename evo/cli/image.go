@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/naoina/toml"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+// Image is a script together with the state of the machine running it.
+type Image struct {
+	Code []vm.Op
+	// Width is the width of the runtime the state was captured from.
+	// Images saved before it was recorded are 8-bit.
+	Width vm.Width
+	State vm.Snapshot
+}
+
+// NewImage captures state, run by a runtime of the given width, as an image.
+func NewImage(state *vm.State, width vm.Width) Image {
+	return Image{
+		Code:  state.Script.Code,
+		Width: width,
+		State: state.Snapshot(),
+	}
+}
+
+// NewState returns a state that resumes the image. It fails if the image's
+// stack or registers hold values that do not fit in its width. The state
+// should be run by a runtime of that width.
+func (img Image) NewState() (vm.State, error) {
+	if err := img.check(); err != nil {
+		return vm.State{}, err
+	}
+	return vm.NewStateFromSnapshot(img.Code, img.State)
+}
+
+// check reports an error if the image's width does not hold its values.
+func (img Image) check() error {
+	if !img.Width.Valid() {
+		return fmt.Errorf("invalid image width %d", int(img.Width))
+	}
+	fits := func(vals []vm.Value) error {
+		for _, val := range vals {
+			if !img.Width.Contains(val) {
+				return fmt.Errorf("image value %d does not fit in its width %v", val, img.Width)
+			}
+		}
+		return nil
+	}
+	for _, frame := range img.State.Stack {
+		if err := fits(frame.Values); err != nil {
+			return err
+		}
+	}
+	return fits(img.State.Registers)
+}
+
+// ImageFormat ...
+type ImageFormat string
+
+const (
+	// ImageFormatNone ...
+	ImageFormatNone ImageFormat = ""
+	// ImageFormatTOML ...
+	ImageFormatTOML ImageFormat = "toml"
+	// ImageFormatJSON ...
+	ImageFormatJSON ImageFormat = "json"
+)
+
+// ImageExtensions ...
+var ImageExtensions = map[string]ImageFormat{
+	".toml": ImageFormatTOML,
+	".json": ImageFormatJSON,
+}
+
+// MarshalImage ...
+func MarshalImage(f ImageFormat, img Image) ([]byte, error) {
+	switch f {
+	case ImageFormatTOML:
+		return toml.Marshal(img)
+	case ImageFormatJSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown image format: %q", string(f))
+	}
+}
+
+// UnmarshalImage ...
+func UnmarshalImage(f ImageFormat, p []byte) (Image, error) {
+	var img Image
+	var err error
+	switch f {
+	case ImageFormatTOML:
+		err = toml.Unmarshal(p, &img)
+	case ImageFormatJSON:
+		err = json.Unmarshal(p, &img)
+	default:
+		err = fmt.Errorf("unknown image format: %q", string(f))
+	}
+	return img, err
+}
+
+// SaveImage writes img to filename in the format given by its extension.
+func SaveImage(filename string, img Image) error {
+	f, err := imageFormat(filename)
+	if err != nil {
+		return err
+	}
+	b, err := MarshalImage(f, img)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+// LoadImage reads an image from filename in the format given by its extension.
+func LoadImage(filename string) (Image, error) {
+	f, err := imageFormat(filename)
+	if err != nil {
+		return Image{}, err
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return Image{}, err
+	}
+	return UnmarshalImage(f, b)
+}
+
+func imageFormat(filename string) (ImageFormat, error) {
+	f, ok := ImageExtensions[filepath.Ext(filename)]
+	if !ok {
+		return ImageFormatNone, fmt.Errorf("could not determine image format for %q", filename)
+	}
+	return f, nil
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+func TestMarshalUnmarshalImage(t *testing.T) {
+	img := Image{
+		Code: []vm.Op{
+			{Type: vm.OpPush, Arg: -3},
+			{Type: vm.OpCall, Arg: 1},
+			{Type: vm.OpLabel, Arg: 1},
+			{Type: vm.OpReturn, Arg: 1},
+		},
+		Width: vm.Width16,
+		State: vm.Snapshot{
+			Iptr: 3,
+			Stack: []vm.FrameSnapshot{
+				{Values: []vm.Value{1, -2}},
+				{Return: 2, Values: []vm.Value{-3}},
+				{Return: 2},
+			},
+			Registers: []vm.Value{0, 300, -128},
+			Gas:       42,
+		},
+	}
+	for _, f := range []ImageFormat{ImageFormatTOML, ImageFormatJSON} {
+		t.Run(string(f), func(t *testing.T) {
+			b, err := MarshalImage(f, img)
+			require.NoError(t, err)
+			got, err := UnmarshalImage(f, b)
+			require.NoError(t, err)
+			state, err := got.NewState()
+			require.NoError(t, err)
+			want, err := img.NewState()
+			require.NoError(t, err)
+			assert.Equal(t, want.Snapshot(), state.Snapshot())
+			assert.Equal(t, img.Code, got.Code)
+			assert.Equal(t, img.Width, got.Width)
+		})
+	}
+}
+
+func TestImage_Width(t *testing.T) {
+	// images saved before the width was recorded are 8-bit
+	img, err := UnmarshalImage(ImageFormatJSON, []byte(`{"State": {"Registers": [127]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, vm.Width8, img.Width)
+	_, err = img.NewState()
+	assert.NoError(t, err)
+
+	img.State.Registers[0] = 300
+	_, err = img.NewState()
+	assert.Error(t, err, "300 does not fit in 8 bits")
+	img.Width = vm.Width16
+	_, err = img.NewState()
+	assert.NoError(t, err)
+	img.Width = vm.Width(-1)
+	_, err = img.NewState()
+	assert.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package genome
+
+import (
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/analysis"
+)
+
+// Check reports whether a genome is worth evaluating. Checks are static, so
+// they are much cheaper than running the genome.
+type Check func(code []vm.Op) bool
+
+// Writes returns a check that accepts genomes which may write at least one
+// of outputs when run with the given number of registers.
+func Writes(registers int, outputs ...int) Check {
+	return func(code []vm.Op) bool {
+		r := analysis.Analyze(code, analysis.Options{Registers: registers})
+		for _, i := range outputs {
+			if r.MayWrite(i) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Retry calls breed until check accepts the genome it returns, at most n
+// times, and returns the last genome.
+func Retry(n int, check Check, breed func() []vm.Op) []vm.Op {
+	var code []vm.Op
+	for i := 0; i < n; i++ {
+		code = breed()
+		if check(code) {
+			break
+		}
+	}
+	return code
+}
@@ -0,0 +1,44 @@
+package genome
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+)
+
+func TestWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{name: "store to an output", src: "push 1\nstore 2\n", want: true},
+		{name: "store to another register", src: "push 1\nstore 4\n"},
+		{name: "unreachable store", src: "push 1\njumpif 2\npush 1\nstore 0\n"},
+		{name: "indirect store", src: "push 9\nstore 9\n", want: true},
+		{name: "no stores", src: "load 0\nload 1\nadd\n"},
+	}
+	check := Writes(8, 0, 1, 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := evo.Unmarshal([]byte(tt.src))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, check(code))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int
+	breed := func() []vm.Op {
+		calls++
+		return make([]vm.Op, calls)
+	}
+	long := func(code []vm.Op) bool { return len(code) >= 3 }
+	assert.Len(t, Retry(5, long, breed), 3)
+	calls = 0
+	assert.Len(t, Retry(2, long, breed), 2)
+}
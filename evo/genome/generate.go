@@ -1,6 +1,8 @@
 package genome
 
 import (
+	"sort"
+
 	"github.com/jncornett/beans-engine/evo/vm"
 	"github.com/jncornett/beans-engine/pkg/discrete"
 )
@@ -13,7 +15,7 @@ type OpCodeVar struct {
 // Sample ...
 func (ov OpCodeVar) Sample() vm.OpCode {
 	i := ov.IntVar.Sample()
-	if i < 0 || i >= int64(vm.OpMax) {
+	if _, ok := vm.OpCode(i).Info(); !ok {
 		return vm.OpNoop
 	}
 	return vm.OpCode(i)
@@ -22,15 +24,19 @@ func (ov OpCodeVar) Sample() vm.OpCode {
 // ValueVar defines a random variable over Values.
 type ValueVar struct {
 	discrete.IntVar
+	// Width, if set, bounds the samples. Samples that do not fit in it are
+	// replaced by 0. A nil Width leaves the samples unbounded, since the
+	// zero Width is Width8.
+	Width *vm.Width
 }
 
 // Sample ...
 func (vv ValueVar) Sample() vm.Value {
-	i := vv.IntVar.Sample()
-	if i < vm.MinValue || i > vm.MaxValue {
+	val := vm.Value(vv.IntVar.Sample())
+	if vv.Width != nil && !vv.Width.Contains(val) {
 		return 0
 	}
-	return vm.Value(i)
+	return val
 }
 
 // OpConfig ...
@@ -47,9 +53,16 @@ type OpVar struct {
 
 // NewOpVar ...
 func NewOpVar(config map[vm.OpCode]OpConfig) OpVar {
+	// the opcodes are ordered so that a seeded source samples the same ops
+	opCodes := make([]vm.OpCode, 0, len(config))
+	for opCode := range config {
+		opCodes = append(opCodes, opCode)
+	}
+	sort.Slice(opCodes, func(i, j int) bool { return opCodes[i] < opCodes[j] })
 	var opCodePMF []discrete.IntVarPoint
 	argVarMap := make(map[vm.OpCode]ValueVar)
-	for opCode, c := range config {
+	for _, opCode := range opCodes {
+		c := config[opCode]
 		opCodePMF = append(opCodePMF, discrete.IntVarPoint{
 			X: discrete.Const(int64(opCode)),
 			Y: c.Weight,
@@ -72,22 +85,29 @@ func (ov OpVar) Sample() vm.Op {
 	return vm.Op{Type: op, Arg: val}
 }
 
-// Default ...
-var Default = NewOpVar(map[vm.OpCode]OpConfig{
-	vm.OpNoop:    OpConfig{Weight: 3, Arg: ValueVar{discrete.Const(0)}},
-	vm.OpPush:    OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(0, 9)}},
-	vm.OpPop:     OpConfig{Weight: 2, Arg: ValueVar{discrete.Const(0)}},
-	vm.OpCall:    OpConfig{Weight: 1, Arg: ValueVar{discrete.Range(0, 9)}},
-	vm.OpReturn:  OpConfig{Weight: 1, Arg: ValueVar{discrete.Const(0)}},
-	vm.OpJumpIf:  OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(-8, 9)}},
-	vm.OpCompare: OpConfig{Weight: 1, Arg: ValueVar{discrete.Const(0)}},
-	vm.OpNot:     OpConfig{Weight: 1, Arg: ValueVar{discrete.Const(0)}},
-	vm.OpInc:     OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(0, 3)}},
-	vm.OpDec:     OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(0, 3)}},
-	vm.OpLoad:    OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(0, 9)}},
-	vm.OpStore:   OpConfig{Weight: 2, Arg: ValueVar{discrete.Range(0, 9)}},
-	vm.OpLabel:   OpConfig{Weight: 1, Arg: ValueVar{discrete.Range(0, 9)}},
-})
+// Registered returns an OpVar over the registered opcodes, weighted by
+// their OpInfo.Weight, with args drawn uniformly from their arg range.
+func Registered() OpVar {
+	config := make(map[vm.OpCode]OpConfig)
+	for _, op := range vm.RegisteredOps() {
+		info, _ := op.Info()
+		if info.Weight <= 0 {
+			continue
+		}
+		// the arg range already bounds the samples
+		arg := ValueVar{IntVar: discrete.Const(int64(info.MinArg))}
+		if info.MinArg < info.MaxArg {
+			arg.IntVar = discrete.Range(int64(info.MinArg), int64(info.MaxArg)+1)
+		}
+		config[op] = OpConfig{Weight: info.Weight, Arg: arg}
+	}
+	return NewOpVar(config)
+}
+
+// Default samples the opcodes that are registered when the package is
+// initialized. Programs that register their own opcodes should call
+// Registered once they have done so.
+var Default = Registered()
 
 // SampleN ...
 func SampleN(ov OpVar, n int) []vm.Op {
@@ -0,0 +1,29 @@
+package genome
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/pkg/discrete"
+)
+
+func TestValueVar(t *testing.T) {
+	width8, width16 := vm.Width8, vm.Width16
+	tests := []struct {
+		name string
+		vv   ValueVar
+		want vm.Value
+	}{
+		{name: "zero value is unbounded", vv: ValueVar{IntVar: discrete.Const(1000)}, want: 1000},
+		{name: "in width", vv: ValueVar{IntVar: discrete.Const(1000), Width: &width16}, want: 1000},
+		{name: "out of width", vv: ValueVar{IntVar: discrete.Const(1000), Width: &width8}},
+		{name: "min of width", vv: ValueVar{IntVar: discrete.Const(-128), Width: &width8}, want: -128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.vv.Sample())
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package analysis computes static facts about scripts.
+package analysis
+
+import (
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/cfg"
+)
+
+// Options describes the runtime a script is analyzed for.
+type Options struct {
+	// Registers is the number of registers the script runs with, or 0 if it
+	// is unknown.
+	Registers int
+	// CallArgs is the number of values a call moves into the new frame.
+	CallArgs int
+	// Width is the width of the runtime's values.
+	Width vm.Width
+}
+
+// StaticRegister reports whether a load or store addresses its register
+// directly rather than through the stack. It is false if the number of
+// registers is unknown.
+func (opts Options) StaticRegister(instr vm.Op) bool {
+	i := int(instr.Arg)
+	return i >= 0 && i < opts.Registers
+}
+
+// Effect returns how many values an instruction needs on the current frame
+// and how it changes the number of values when it does not fault. ok is
+// false if the effect is unknown, which is the case for opcodes whose
+// OpInfo has no Effect and for loads and stores when the number of
+// registers is unknown.
+func (opts Options) Effect(instr vm.Op) (need, delta int, ok bool) {
+	e, ok := opts.effect(instr)
+	if !ok {
+		return 0, 0, false
+	}
+	return e.In, e.Out - e.In, true
+}
+
+func (opts Options) effect(instr vm.Op) (e vm.Effect, ok bool) {
+	e, ok = vm.EffectOf(instr, opts.Registers)
+	if !ok || (e.Register != vm.AccessNone && opts.Registers == 0) {
+		return vm.Effect{}, false
+	}
+	return e, true
+}
+
+// Frame describes what is known about the current frame before an
+// instruction runs.
+type Frame struct {
+	// Reached is false if the instruction can never run.
+	Reached bool
+	// The number of values on the frame lies in [Lo, Hi].
+	Lo, Hi int
+	// Top is the value on top of the frame if TopKnown is set.
+	Top      vm.Value
+	TopKnown bool
+	// The number of frames on the stack, counting the base frame, lies in
+	// [MinDepth, MaxDepth].
+	MinDepth, MaxDepth int
+}
+
+func (f Frame) join(other Frame) Frame {
+	if !f.Reached {
+		return other
+	}
+	if !other.Reached {
+		return f
+	}
+	out := Frame{
+		Reached:  true,
+		Lo:       min(f.Lo, other.Lo),
+		Hi:       max(f.Hi, other.Hi),
+		MinDepth: min(f.MinDepth, other.MinDepth),
+		MaxDepth: max(f.MaxDepth, other.MaxDepth),
+	}
+	if f.TopKnown && other.TopKnown && f.Top == other.Top {
+		out.Top, out.TopKnown = f.Top, true
+	}
+	return out
+}
+
+// after returns the frame following an instruction that continues with the
+// next one.
+func (opts Options) after(instr vm.Op, f Frame) Frame {
+	e, ok := opts.effect(instr)
+	if !ok {
+		// nothing is known about what a host function does to the stack
+		return Frame{Reached: true, Lo: 0, Hi: vm.FrameSize, MinDepth: 1, MaxDepth: vm.MaxFrames}
+	}
+	need, delta := e.In, e.Out-e.In
+	out := Frame{
+		Reached:  true,
+		Lo:       clampDepth(f.Lo + delta),
+		Hi:       clampDepth(f.Hi + delta),
+		MinDepth: f.MinDepth,
+		MaxDepth: f.MaxDepth,
+	}
+	if f.Lo < need {
+		// An instruction that underflows either leaves the frame alone or
+		// pops what it can and pushes its results anyway.
+		out.Lo = 0
+		if hi := clampDepth(max(min(f.Hi, need-1), need+delta)); hi > out.Hi {
+			out.Hi = hi
+		}
+	}
+	switch instr.Type {
+	case vm.OpNoop, vm.OpLabel:
+		out.Top, out.TopKnown = f.Top, f.TopKnown
+	case vm.OpPush:
+		// an arg that does not fit is wrapped or saturated
+		out.Top, out.TopKnown = instr.Arg, f.Hi < vm.FrameSize && opts.Width.Contains(instr.Arg)
+	case vm.OpDup:
+		out.Top, out.TopKnown = f.Top, f.TopKnown && f.Lo >= 1 && f.Hi < vm.FrameSize
+	case vm.OpStore:
+		if opts.StaticRegister(instr) {
+			out.Top, out.TopKnown = f.Top, f.TopKnown
+		}
+	}
+	return out
+}
+
+func clampDepth(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > vm.FrameSize {
+		return vm.FrameSize
+	}
+	return n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type successor struct {
+	to    int
+	frame Frame
+}
+
+// successors returns the instructions that may run after the one at i.
+func (opts Options) successors(code []vm.Op, labels *vm.LabelIndex, i int, f Frame) []successor {
+	instr := code[i]
+	next := successor{to: i + 1, frame: opts.after(instr, f)}
+	e, _ := opts.effect(instr)
+	switch e.Flow {
+	case vm.FlowBranch, vm.FlowBranchIfZero:
+		jump := successor{to: cfg.JumpTarget(len(code), i, instr.Arg), frame: next.frame}
+		switch {
+		case f.Hi == 0:
+			return []successor{next} // popping from an empty frame never jumps
+		case f.TopKnown && f.Lo >= 1 && (f.Top != 0) == (e.Flow == vm.FlowBranch):
+			return []successor{jump}
+		case f.TopKnown && f.Lo >= 1:
+			return []successor{next}
+		}
+		return []successor{jump, next}
+	case vm.FlowJump:
+		return []successor{{to: cfg.JumpTarget(len(code), i, instr.Arg), frame: next.frame}}
+	case vm.FlowJumpToLabel:
+		if to, ok := cfg.CallTarget(labels, i, instr.Arg); ok {
+			return []successor{{to: to, frame: next.frame}}
+		}
+		return []successor{next}
+	case vm.FlowIndirect:
+		if f.Hi == 0 {
+			return []successor{next}
+		}
+		if f.TopKnown && f.Lo >= 1 {
+			return []successor{{to: cfg.JumpTarget(len(code), i, f.Top), frame: next.frame}}
+		}
+		var out []successor
+		for _, to := range cfg.IndirectTargets(len(code), i, opts.Width) {
+			out = append(out, successor{to: to, frame: next.frame})
+		}
+		if f.Lo < 1 {
+			out = append(out, next)
+		}
+		return out
+	case vm.FlowCall:
+		to, ok := cfg.CallTarget(labels, i, instr.Arg)
+		if !ok {
+			return []successor{next}
+		}
+		n := opts.CallArgs
+		if n < 0 {
+			n = 0
+		}
+		// the caller resumes with fewer values plus whatever was returned,
+		// or with its frame untouched if the call overflowed
+		resume := successor{to: i + 1, frame: Frame{
+			Reached:  true,
+			Lo:       clampDepth(f.Lo - n),
+			Hi:       vm.FrameSize,
+			MinDepth: f.MinDepth,
+			MaxDepth: f.MaxDepth,
+		}}
+		if f.MinDepth >= vm.MaxFrames {
+			return []successor{resume}
+		}
+		callee := successor{to: to, frame: Frame{
+			Reached:  true,
+			Lo:       min(n, f.Lo),
+			Hi:       min(n, f.Hi),
+			MinDepth: f.MinDepth + 1,
+			MaxDepth: min(f.MaxDepth+1, vm.MaxFrames),
+		}}
+		return []successor{callee, resume}
+	case vm.FlowReturn:
+		if f.MinDepth > 1 {
+			// the caller resumes after its call
+			return nil
+		}
+		// returning from the base frame faults and falls through
+		return []successor{{to: i + 1, frame: f}}
+	}
+	return []successor{next}
+}
+
+// Frames computes what is known about the current frame before each
+// instruction of code, which starts with an empty stack.
+//
+// Conditional jumps whose condition is known only go one way, so the
+// instructions they skip are not reached. Indirect jumps whose offset is
+// not known may land on any instruction in reach. Calls are assumed to return to the
+// instruction after them with any number of values.
+func Frames(code []vm.Op, opts Options) []Frame {
+	frames := make([]Frame, len(code))
+	if len(code) == 0 {
+		return frames
+	}
+	labels := vm.NewLabelIndex(code)
+	frames[0] = Frame{Reached: true, MinDepth: 1, MaxDepth: 1}
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, succ := range opts.successors(code, labels, i, frames[i]) {
+			if succ.to >= len(code) {
+				continue
+			}
+			joined := frames[succ.to].join(succ.frame)
+			if joined != frames[succ.to] {
+				frames[succ.to] = joined
+				work = append(work, succ.to)
+			}
+		}
+	}
+	return frames
+}
@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+func TestFrames(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: 1},   // 0
+		{Type: vm.OpLabel, Arg: 1},  // 1
+		{Type: vm.OpDup},            // 2
+		{Type: vm.OpJumpIf, Arg: 1}, // 3: the condition is always true
+		{Type: vm.OpNoop},           // 4
+		{Type: vm.OpLoad, Arg: 9},   // 5: dynamic
+		{Type: vm.OpLoad, Arg: 0},   // 6
+		{Type: vm.OpAdd},            // 7
+	}
+	frames := Frames(code, Options{Registers: 2})
+	assert.Equal(t, []Frame{
+		{Reached: true, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 1, Hi: 1, Top: 1, TopKnown: true, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 1, Hi: 1, Top: 1, TopKnown: true, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 2, Hi: 2, Top: 1, TopKnown: true, MinDepth: 1, MaxDepth: 1},
+		{},
+		{Reached: true, Lo: 1, Hi: 1, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 1, Hi: 1, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 2, Hi: 2, MinDepth: 1, MaxDepth: 1},
+	}, frames)
+}
+
+func TestFrames_Unknown(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpLoad, Arg: 0},
+		{Type: vm.OpSyscall},
+		{Type: vm.OpNoop},
+	}
+	frames := Frames(code, Options{})
+	unknown := Frame{Reached: true, Lo: 0, Hi: vm.FrameSize, MinDepth: 1, MaxDepth: vm.MaxFrames}
+	assert.Equal(t, unknown, frames[1])
+	assert.Equal(t, unknown, frames[2])
+}
+
+func TestFrames_Calls(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: 1},   // 0
+		{Type: vm.OpJumpIf, Arg: 3}, // 1: always jumps to the call
+		{Type: vm.OpLabel, Arg: 1},  // 2
+		{Type: vm.OpPush, Arg: 7},   // 3
+		{Type: vm.OpReturn, Arg: 1}, // 4: always returns to the caller
+		{Type: vm.OpCall, Arg: 1},   // 5
+		{Type: vm.OpNoop},           // 6
+	}
+	frames := Frames(code, Options{})
+	assert.Equal(t, []Frame{
+		{Reached: true, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 1, Hi: 1, Top: 1, TopKnown: true, MinDepth: 1, MaxDepth: 1},
+		{},
+		{Reached: true, MinDepth: 2, MaxDepth: 2},
+		{Reached: true, Lo: 1, Hi: 1, Top: 7, TopKnown: true, MinDepth: 2, MaxDepth: 2},
+		{Reached: true, MinDepth: 1, MaxDepth: 1},
+		{Reached: true, Lo: 0, Hi: vm.FrameSize, MinDepth: 1, MaxDepth: 1},
+	}, frames)
+}
+
+func TestFrames_Jumps(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: 0},        // 0
+		{Type: vm.OpJumpIfZero, Arg: 1},  // 1: the condition is always zero
+		{Type: vm.OpNoop},                // 2
+		{Type: vm.OpPush, Arg: 2},        // 3
+		{Type: vm.OpJumpIndirect},        // 4: always jumps to the label
+		{Type: vm.OpNoop},                // 5
+		{Type: vm.OpJumpToLabel, Arg: 1}, // 6
+		{Type: vm.OpLabel, Arg: 1},       // 7
+		{Type: vm.OpJump, Arg: 1},        // 8
+		{Type: vm.OpNoop},                // 9
+		{Type: vm.OpNoop},                // 10
+	}
+	frames := Frames(code, Options{})
+	var reached []int
+	for i, f := range frames {
+		if f.Reached {
+			reached = append(reached, i)
+		}
+	}
+	assert.Equal(t, []int{0, 1, 3, 4, 7, 8, 10}, reached)
+
+	// an unknown offset may land anywhere
+	frames = Frames([]vm.Op{{Type: vm.OpLoad}, {Type: vm.OpJumpIndirect}, {Type: vm.OpNoop}, {Type: vm.OpNoop}}, Options{Registers: 1})
+	for _, f := range frames {
+		assert.True(t, f.Reached)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpLoad, Arg: 0},  // reads an input
+		{Type: vm.OpStore, Arg: 1}, // writes register 1
+		{Type: vm.OpLoad, Arg: 1},  // reads what was just written
+		{Type: vm.OpLabel, Arg: 2}, // recursion
+		{Type: vm.OpPush, Arg: 3},
+		{Type: vm.OpCall, Arg: 2},
+		{Type: vm.OpStore, Arg: 9}, // indirect
+	}
+	r := Analyze(code, Options{Registers: 4})
+	assert.Equal(t, []int{0}, r.Inputs)
+	assert.Equal(t, []int{1}, r.Outputs)
+	assert.False(t, r.IndirectLoad)
+	assert.True(t, r.IndirectStore)
+	assert.True(t, r.MayRead(0))
+	assert.False(t, r.MayRead(1))
+	assert.True(t, r.MayWrite(3))
+	assert.Equal(t, []int{5}, r.FrameOverflow)
+}
+
+func TestAnalyze_StackOverflow(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: 1},
+		{Type: vm.OpPush, Arg: 1},
+		{Type: vm.OpJumpIf, Arg: -3}, // grows the frame on every pass
+	}
+	r := Analyze(code, Options{})
+	assert.Equal(t, []int{1}, r.StackOverflow)
+	assert.Nil(t, r.FrameOverflow)
+}
+
+func TestAnalyze_ConditionalWrite(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpLoad, Arg: 0},
+		{Type: vm.OpJumpIf, Arg: 2},
+		{Type: vm.OpPush, Arg: 1},
+		{Type: vm.OpStore, Arg: 1}, // skipped by the jump
+		{Type: vm.OpLoad, Arg: 1},
+	}
+	r := Analyze(code, Options{Registers: 2})
+	assert.Equal(t, []int{0, 1}, r.Inputs)
+	assert.Equal(t, []int{1}, r.Outputs)
+}
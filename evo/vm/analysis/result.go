@@ -0,0 +1,175 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+// Result summarizes what a script may do when it runs.
+type Result struct {
+	// Frames describes the current frame before each instruction.
+	Frames []Frame
+	// Inputs are the registers that may be read before they are written,
+	// in increasing order.
+	Inputs []int
+	// Outputs are the registers that may be written, in increasing order.
+	Outputs []int
+	// IndirectLoad is set if a load may read a register named by a stack
+	// value, which can be any register. IndirectStore is the same for
+	// stores.
+	IndirectLoad, IndirectStore bool
+	// StackOverflow lists the instructions that may push past FrameSize.
+	StackOverflow []int
+	// FrameOverflow lists the calls that may push past MaxFrames.
+	FrameOverflow []int
+}
+
+// MayRead reports whether the script may read register i before writing it.
+func (r Result) MayRead(i int) bool {
+	return r.IndirectLoad || contains(r.Inputs, i)
+}
+
+// MayWrite reports whether the script may write register i.
+func (r Result) MayWrite(i int) bool {
+	return r.IndirectStore || contains(r.Outputs, i)
+}
+
+func contains(sorted []int, i int) bool {
+	j := sort.SearchInts(sorted, i)
+	return j < len(sorted) && sorted[j] == i
+}
+
+// Analyze computes the frames of code along with the registers it uses and
+// the instructions that may overflow the machine's limits. Registers are
+// only tracked if opts.Registers is known.
+func Analyze(code []vm.Op, opts Options) Result {
+	frames := Frames(code, opts)
+	written := opts.written(code, frames)
+	var (
+		r       = Result{Frames: frames}
+		inputs  = make(map[int]bool)
+		outputs = make(map[int]bool)
+	)
+	for i, instr := range code {
+		f := frames[i]
+		if !f.Reached {
+			continue
+		}
+		e, ok := opts.effect(instr)
+		if !ok {
+			continue
+		}
+		if delta := e.Out - e.In; delta > 0 && f.Hi+delta > vm.FrameSize {
+			r.StackOverflow = append(r.StackOverflow, i)
+		}
+		if e.Flow == vm.FlowCall && f.MaxDepth >= vm.MaxFrames {
+			r.FrameOverflow = append(r.FrameOverflow, i)
+		}
+		static := opts.StaticRegister(instr)
+		switch {
+		case e.Register == vm.AccessRead && static:
+			if !written[i].has(int(instr.Arg)) {
+				inputs[int(instr.Arg)] = true
+			}
+		case e.Register == vm.AccessRead:
+			r.IndirectLoad = true
+		case e.Register == vm.AccessWrite && static:
+			outputs[int(instr.Arg)] = true
+		case e.Register == vm.AccessWrite:
+			r.IndirectStore = true
+		}
+	}
+	r.Inputs = sortedKeys(inputs)
+	r.Outputs = sortedKeys(outputs)
+	return r
+}
+
+func sortedKeys(m map[int]bool) []int {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make([]int, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// registerSet is a set of register indices.
+type registerSet []uint64
+
+func newRegisterSet(n int) registerSet {
+	return make(registerSet, (n+63)/64)
+}
+
+func (s registerSet) has(i int) bool {
+	return i >= 0 && i/64 < len(s) && s[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (s registerSet) with(i int) registerSet {
+	out := append(registerSet(nil), s...)
+	out[i/64] |= 1 << uint(i%64)
+	return out
+}
+
+// intersect returns the registers in both s and other. A nil set stands for
+// every register.
+func (s registerSet) intersect(other registerSet) registerSet {
+	if s == nil {
+		return other
+	}
+	out := make(registerSet, len(s))
+	for i := range s {
+		out[i] = s[i] & other[i]
+	}
+	return out
+}
+
+func (s registerSet) equal(other registerSet) bool {
+	if (s == nil) != (other == nil) {
+		return false
+	}
+	for i := range s {
+		if s[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// written computes the registers that are written on every path to each
+// reached instruction.
+func (opts Options) written(code []vm.Op, frames []Frame) []registerSet {
+	sets := make([]registerSet, len(code))
+	if len(code) == 0 || opts.Registers <= 0 {
+		return sets
+	}
+	labels := vm.NewLabelIndex(code)
+	sets[0] = newRegisterSet(opts.Registers)
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		out := sets[i]
+		instr := code[i]
+		e, ok := opts.effect(instr)
+		if ok && e.Register == vm.AccessWrite && opts.StaticRegister(instr) && frames[i].Lo >= e.In {
+			// the instruction cannot underflow, so it always writes
+			out = out.with(int(instr.Arg))
+		}
+		for _, succ := range opts.successors(code, labels, i, frames[i]) {
+			if succ.to >= len(code) {
+				continue
+			}
+			// calls enter and resume with the registers written so far
+			joined := sets[succ.to].intersect(out)
+			if sets[succ.to] == nil || !joined.equal(sets[succ.to]) {
+				sets[succ.to] = joined
+				work = append(work, succ.to)
+			}
+		}
+	}
+	return sets
+}
@@ -0,0 +1,166 @@
+package vm
+
+import "math"
+
+// Overflow selects how arithmetic results that do not fit in the width of a
+// Value are handled.
+type Overflow int
+
+const (
+	// OverflowWrap wraps results around, as in two's complement arithmetic.
+	// The largest value of a width plus one is its smallest.
+	OverflowWrap Overflow = iota
+	// OverflowSaturate clamps results to the range of the width.
+	// The largest value of a width plus one is itself.
+	OverflowSaturate
+)
+
+// Arith does arithmetic on values of a width, handling results that do not
+// fit in it according to an overflow mode. The zero Arith wraps 8-bit
+// values.
+type Arith struct {
+	Overflow Overflow
+	Width    Width
+}
+
+// Fit converts x to a value of the width according to the overflow mode.
+func (a Arith) Fit(x int64) Value {
+	if a.Width >= Width64 {
+		return Value(x)
+	}
+	// x is in range if wrapping it to the width leaves it unchanged
+	shift := 64 - a.Width.Bits()
+	wrapped := x << shift >> shift
+	if wrapped == x || a.Overflow != OverflowSaturate {
+		return Value(wrapped)
+	}
+	if x > 0 {
+		return a.Width.Max()
+	}
+	return a.Width.Min()
+}
+
+// small reports whether x and y fit in 32 bits. Sums, differences and
+// products of such values cannot overflow 64 bits, so they skip the checks.
+func small(x, y Value) bool {
+	return Value(int32(x)) == x && Value(int32(y)) == y
+}
+
+// overflowed returns the result of an operation whose result did not fit in
+// 64 bits. x is the result wrapped to 64 bits, and positive is the sign of
+// the true result.
+func (a Arith) overflowed(x int64, positive bool) Value {
+	if a.Overflow == OverflowSaturate {
+		if positive {
+			return a.Width.Max()
+		}
+		return a.Width.Min()
+	}
+	return a.Fit(x)
+}
+
+// Add ...
+func (a Arith) Add(x, y Value) Value {
+	if small(x, y) {
+		return a.Fit(int64(x + y))
+	}
+	sum := int64(x) + int64(y)
+	if (int64(x)^sum)&(int64(y)^sum) < 0 {
+		return a.overflowed(sum, y > 0)
+	}
+	return a.Fit(sum)
+}
+
+// Sub ...
+func (a Arith) Sub(x, y Value) Value {
+	if small(x, y) {
+		return a.Fit(int64(x - y))
+	}
+	diff := int64(x) - int64(y)
+	if (int64(x)^int64(y))&(int64(x)^diff) < 0 {
+		return a.overflowed(diff, y < 0)
+	}
+	return a.Fit(diff)
+}
+
+// Mul ...
+func (a Arith) Mul(x, y Value) Value {
+	if small(x, y) {
+		return a.Fit(int64(x * y))
+	}
+	prod := int64(x) * int64(y)
+	if x != 0 && (prod/int64(x) != int64(y) || x == -1 && y == math.MinInt64) {
+		return a.overflowed(prod, (x < 0) == (y < 0))
+	}
+	return a.Fit(prod)
+}
+
+// Neg ...
+func (a Arith) Neg(x Value) Value {
+	if x == math.MinInt64 {
+		return a.overflowed(math.MinInt64, true)
+	}
+	return a.Fit(-int64(x))
+}
+
+// Div divides x by y, truncating toward zero.
+// Dividing by zero yields 0 and ok is false.
+func (a Arith) Div(x, y Value) (val Value, ok bool) {
+	if y == 0 {
+		return 0, false
+	}
+	if x == math.MinInt64 && y == -1 {
+		return a.overflowed(math.MinInt64, true), true
+	}
+	return a.Fit(int64(x) / int64(y)), true
+}
+
+// Mod computes the remainder of x divided by y, which has the sign of x.
+// Dividing by zero yields 0 and ok is false.
+func (a Arith) Mod(x, y Value) (val Value, ok bool) {
+	if y == 0 {
+		return 0, false
+	}
+	return a.Fit(int64(x) % int64(y)), true
+}
+
+// Shl shifts x left by n bits. A negative n shifts right instead.
+func (a Arith) Shl(x, n Value) Value {
+	if n < 0 {
+		return a.shr(x, shiftCount(n))
+	}
+	return a.shl(x, shiftCount(n))
+}
+
+// Shr shifts x right by n bits, preserving its sign.
+// A negative n shifts left instead.
+func (a Arith) Shr(x, n Value) Value {
+	if n < 0 {
+		return a.shl(x, shiftCount(n))
+	}
+	return a.shr(x, shiftCount(n))
+}
+
+func (a Arith) shl(x Value, n uint) Value {
+	shifted := int64(x) << n
+	if shifted>>n != int64(x) {
+		return a.overflowed(shifted, x > 0)
+	}
+	return a.Fit(shifted)
+}
+
+func (a Arith) shr(x Value, n uint) Value {
+	return a.Fit(int64(x) >> n)
+}
+
+// shiftCount returns the magnitude of a shift count, limited to 64 bits,
+// beyond which the result no longer changes.
+func shiftCount(n Value) uint {
+	if n < -64 || n > 64 {
+		return 64
+	}
+	if n < 0 {
+		return uint(-n)
+	}
+	return uint(n)
+}
@@ -0,0 +1,222 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		name         string
+		fn           func(Arith) Value
+		wantWrap     Value
+		wantSaturate Value
+	}{
+		{
+			name:         "add",
+			fn:           func(a Arith) Value { return a.Add(3, 4) },
+			wantWrap:     7,
+			wantSaturate: 7,
+		},
+		{
+			name:         "add overflow",
+			fn:           func(a Arith) Value { return a.Add(MaxValue, 1) },
+			wantWrap:     MinValue,
+			wantSaturate: MaxValue,
+		},
+		{
+			name:         "sub underflow",
+			fn:           func(a Arith) Value { return a.Sub(MinValue, 1) },
+			wantWrap:     MaxValue,
+			wantSaturate: MinValue,
+		},
+		{
+			name:         "mul overflow",
+			fn:           func(a Arith) Value { return a.Mul(16, 16) },
+			wantWrap:     0,
+			wantSaturate: MaxValue,
+		},
+		{
+			name:         "neg min",
+			fn:           func(a Arith) Value { return a.Neg(MinValue) },
+			wantWrap:     MinValue,
+			wantSaturate: MaxValue,
+		},
+		{
+			name:         "div min by -1",
+			fn:           func(a Arith) Value { v, _ := a.Div(MinValue, -1); return v },
+			wantWrap:     MinValue,
+			wantSaturate: MaxValue,
+		},
+		{
+			name:         "div truncates",
+			fn:           func(a Arith) Value { v, _ := a.Div(-7, 2); return v },
+			wantWrap:     -3,
+			wantSaturate: -3,
+		},
+		{
+			name:         "mod sign",
+			fn:           func(a Arith) Value { v, _ := a.Mod(-7, 3); return v },
+			wantWrap:     -1,
+			wantSaturate: -1,
+		},
+		{
+			name:         "shl",
+			fn:           func(a Arith) Value { return a.Shl(3, 2) },
+			wantWrap:     12,
+			wantSaturate: 12,
+		},
+		{
+			name:         "shl overflow",
+			fn:           func(a Arith) Value { return a.Shl(3, 7) },
+			wantWrap:     MinValue,
+			wantSaturate: MaxValue,
+		},
+		{
+			name:         "shl past width",
+			fn:           func(a Arith) Value { return a.Shl(-1, 100) },
+			wantWrap:     0,
+			wantSaturate: MinValue,
+		},
+		{
+			name:         "shl negative count",
+			fn:           func(a Arith) Value { return a.Shl(12, -2) },
+			wantWrap:     3,
+			wantSaturate: 3,
+		},
+		{
+			name:         "shr sign",
+			fn:           func(a Arith) Value { return a.Shr(-8, 2) },
+			wantWrap:     -2,
+			wantSaturate: -2,
+		},
+		{
+			name:         "shr past width",
+			fn:           func(a Arith) Value { return a.Shr(-8, MaxValue) },
+			wantWrap:     -1,
+			wantSaturate: -1,
+		},
+		{
+			name:         "shr min count",
+			fn:           func(a Arith) Value { return a.Shr(1, MinValue) },
+			wantWrap:     0,
+			wantSaturate: MaxValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantWrap, tt.fn(Arith{Overflow: OverflowWrap}), "wrap")
+			assert.Equal(t, tt.wantSaturate, tt.fn(Arith{Overflow: OverflowSaturate}), "saturate")
+		})
+	}
+}
+
+func TestOverflow_DivideByZero(t *testing.T) {
+	for _, o := range []Overflow{OverflowWrap, OverflowSaturate} {
+		t.Run(fmt.Sprint(o), func(t *testing.T) {
+			a := Arith{Overflow: o}
+			val, ok := a.Div(5, 0)
+			assert.False(t, ok)
+			assert.Equal(t, Value(0), val)
+			val, ok = a.Mod(5, 0)
+			assert.False(t, ok)
+			assert.Equal(t, Value(0), val)
+		})
+	}
+}
+
+func TestArith_Widths(t *testing.T) {
+	for _, w := range Widths {
+		t.Run(w.String(), func(t *testing.T) {
+			wrap := Arith{Overflow: OverflowWrap, Width: w}
+			sat := Arith{Overflow: OverflowSaturate, Width: w}
+			min, max := w.Min(), w.Max()
+			assert.Equal(t, min, wrap.Add(max, 1))
+			assert.Equal(t, max, sat.Add(max, 1))
+			assert.Equal(t, max, wrap.Sub(min, 1))
+			assert.Equal(t, min, sat.Sub(min, 1))
+			assert.Equal(t, Value(-2), wrap.Mul(max, 2))
+			assert.Equal(t, max, sat.Mul(max, 2))
+			assert.Equal(t, min, sat.Mul(max, -2))
+			assert.Equal(t, min, wrap.Mul(-1, min))
+			assert.Equal(t, max, sat.Mul(-1, min))
+			assert.Equal(t, min, wrap.Neg(min))
+			assert.Equal(t, max, sat.Neg(min))
+			v, _ := wrap.Div(min, -1)
+			assert.Equal(t, min, v)
+			v, _ = sat.Div(min, -1)
+			assert.Equal(t, max, v)
+			bits := Value(w.Bits())
+			assert.Equal(t, min, wrap.Shl(1, bits-1))
+			assert.Equal(t, max, sat.Shl(1, bits-1))
+			assert.Equal(t, Value(1)<<uint(bits-2), sat.Shl(1, bits-2))
+			assert.Equal(t, Value(-1), wrap.Shr(min, bits))
+			assert.Equal(t, Value(-2), wrap.Add(max, max))
+		})
+	}
+	assert.Equal(t, Value(44), Arith{}.Fit(300))
+	assert.Equal(t, Value(300), Arith{Width: Width16}.Fit(300))
+}
+
+// TestArith_Reference checks the arithmetic against math/big, on operands
+// either side of the 32-bit boundary below which the overflow checks are
+// skipped.
+func TestArith_Reference(t *testing.T) {
+	vals := []Value{0, 1, -1, 127, -128, 300, math.MaxInt32, math.MinInt32, math.MaxInt32 + 1, math.MinInt32 - 1, math.MaxInt64, math.MinInt64}
+	ops := []struct {
+		name string
+		fn   func(a Arith, x, y Value) Value
+		ref  func(z, x, y *big.Int) *big.Int
+	}{
+		{"add", Arith.Add, (*big.Int).Add},
+		{"sub", Arith.Sub, (*big.Int).Sub},
+		{"mul", Arith.Mul, (*big.Int).Mul},
+	}
+	// fit converts an exact result to the width as Arith should
+	fit := func(a Arith, z *big.Int) Value {
+		min, max := big.NewInt(int64(a.Width.Min())), big.NewInt(int64(a.Width.Max()))
+		switch {
+		case z.Cmp(max) > 0 && a.Overflow == OverflowSaturate:
+			return a.Width.Max()
+		case z.Cmp(min) < 0 && a.Overflow == OverflowSaturate:
+			return a.Width.Min()
+		}
+		mod := new(big.Int).Lsh(big.NewInt(1), a.Width.Bits())
+		z = new(big.Int).Sub(z, min)
+		z.Mod(z, mod).Add(z, min)
+		return Value(z.Int64())
+	}
+	for _, w := range Widths {
+		for _, overflow := range []Overflow{OverflowWrap, OverflowSaturate} {
+			a := Arith{Overflow: overflow, Width: w}
+			for _, op := range ops {
+				for _, x := range vals {
+					for _, y := range vals {
+						want := fit(a, op.ref(new(big.Int), big.NewInt(int64(x)), big.NewInt(int64(y))))
+						assert.Equal(t, want, op.fn(a, x, y), "%v %s(%d, %d) with overflow %d", w, op.name, x, y, overflow)
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkArith_Width8(b *testing.B) {
+	for name, overflow := range map[string]Overflow{"wrap": OverflowWrap, "saturate": OverflowSaturate} {
+		a := Arith{Overflow: overflow}
+		b.Run(name, func(b *testing.B) {
+			var acc Value
+			for i := 0; i < b.N; i++ {
+				x, y := Value(int8(i)), Value(int8(i>>8))
+				acc += a.Add(x, y) + a.Sub(x, y) + a.Mul(x, y)
+			}
+			benchmarkSink = acc
+		})
+	}
+}
+
+var benchmarkSink Value
@@ -0,0 +1,278 @@
+// Package cfg builds control-flow graphs of scripts.
+//
+// The graph over-approximates the paths a script can take: a conditional
+// jump may go either way, a call may fault and fall through, a return may
+// resume after any call in the script, and an indirect jump may land on any
+// instruction its offset can reach. Faults that halt the runtime are not
+// modelled.
+package cfg
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+// Exit is the ID of the virtual block that is reached when execution runs
+// past the end of the script.
+const Exit = -1
+
+// EdgeKind ...
+type EdgeKind int
+
+const (
+	// EdgeFallthrough continues with the next instruction.
+	EdgeFallthrough EdgeKind = iota
+	// EdgeJump is taken by jumps.
+	EdgeJump
+	// EdgeCall enters a subroutine at the instruction after its label.
+	EdgeCall
+	// EdgeReturn resumes after a call.
+	EdgeReturn
+)
+
+func (kind EdgeKind) String() string {
+	switch kind {
+	case EdgeFallthrough:
+		return "fallthrough"
+	case EdgeJump:
+		return "jump"
+	case EdgeCall:
+		return "call"
+	case EdgeReturn:
+		return "return"
+	default:
+		return "EdgeKind(" + strconv.Itoa(int(kind)) + ")"
+	}
+}
+
+// Edge connects two blocks.
+type Edge struct {
+	From, To int
+	Kind     EdgeKind
+}
+
+// Block is a run of instructions that is only entered at its first
+// instruction and only left after its last.
+type Block struct {
+	ID int
+	// Start and End delimit the instructions of the block in the code.
+	Start, End int
+}
+
+// Graph ...
+type Graph struct {
+	Code   []vm.Op
+	Blocks []Block
+	Edges  []Edge
+	// blockAt maps instruction positions to block IDs.
+	blockAt []int
+}
+
+// Build builds the control-flow graph of code run with values of the given
+// width, which bounds the offsets of indirect jumps.
+func Build(code []vm.Op, width vm.Width) *Graph {
+	labels := vm.NewLabelIndex(code)
+	var returns []int
+	leaders := map[int]bool{0: true}
+	targets := make([][]Edge, len(code))
+	for i, instr := range code {
+		var out []Edge
+		switch instr.Type {
+		case vm.OpJumpIf, vm.OpJumpIfZero:
+			out = append(out,
+				Edge{From: i, To: JumpTarget(len(code), i, instr.Arg), Kind: EdgeJump},
+				Edge{From: i, To: i + 1, Kind: EdgeFallthrough},
+			)
+		case vm.OpJump:
+			out = append(out, Edge{From: i, To: JumpTarget(len(code), i, instr.Arg), Kind: EdgeJump})
+		case vm.OpJumpToLabel:
+			if to, ok := CallTarget(labels, i, instr.Arg); ok {
+				out = append(out, Edge{From: i, To: to, Kind: EdgeJump})
+			} else {
+				// a missing label faults and falls through
+				out = append(out, Edge{From: i, To: i + 1, Kind: EdgeFallthrough})
+			}
+		case vm.OpJumpIndirect:
+			for _, to := range IndirectTargets(len(code), i, width) {
+				out = append(out, Edge{From: i, To: to, Kind: EdgeJump})
+			}
+			// popping the offset from an empty frame faults and falls through
+			out = append(out, Edge{From: i, To: i + 1, Kind: EdgeFallthrough})
+		case vm.OpCall:
+			if to, ok := CallTarget(labels, i, instr.Arg); ok {
+				out = append(out, Edge{From: i, To: to, Kind: EdgeCall})
+				returns = append(returns, i+1)
+			}
+			out = append(out, Edge{From: i, To: i + 1, Kind: EdgeFallthrough})
+		case vm.OpReturn:
+			// returning from the base frame faults and falls through
+			out = append(out, Edge{From: i, To: i + 1, Kind: EdgeFallthrough})
+		default:
+			continue
+		}
+		targets[i] = out
+		leaders[i+1] = true
+		for _, e := range out {
+			leaders[e.To] = true
+		}
+	}
+	for i, instr := range code {
+		if instr.Type != vm.OpReturn {
+			continue
+		}
+		for _, to := range returns {
+			targets[i] = append(targets[i], Edge{From: i, To: to, Kind: EdgeReturn})
+		}
+	}
+
+	g := &Graph{Code: code, blockAt: make([]int, len(code))}
+	starts := make([]int, 0, len(leaders))
+	for i := range leaders {
+		if i < len(code) {
+			starts = append(starts, i)
+		}
+	}
+	sort.Ints(starts)
+	for n, start := range starts {
+		end := len(code)
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		for i := start; i < end; i++ {
+			g.blockAt[i] = n
+		}
+		g.Blocks = append(g.Blocks, Block{ID: n, Start: start, End: end})
+	}
+	for _, b := range g.Blocks {
+		last := b.End - 1
+		out := targets[last]
+		if out == nil {
+			out = []Edge{{From: last, To: b.End, Kind: EdgeFallthrough}}
+		}
+		for _, e := range out {
+			g.addEdge(Edge{From: b.ID, To: g.block(e.To), Kind: e.Kind})
+		}
+	}
+	return g
+}
+
+// JumpTarget returns where a jump at iptr with the given offset jumps to in
+// code of length n. An offset of 0 skips the next instruction.
+func JumpTarget(n, iptr int, offset vm.Value) int {
+	// clamp wide offsets before they can overflow
+	switch limit := vm.Value(n); {
+	case offset == 0:
+		offset = 1
+	case offset > limit:
+		offset = limit
+	case offset < -limit:
+		offset = -limit
+	}
+	to := iptr + 1 + int(offset)
+	if to < 0 {
+		return 0
+	}
+	if to > n {
+		return n
+	}
+	return to
+}
+
+// IndirectTargets returns every position an OpJumpIndirect at iptr may jump
+// to in code of length n, with offsets of the given width, in increasing
+// order.
+func IndirectTargets(n, iptr int, width vm.Width) []int {
+	lo := JumpTarget(n, iptr, width.Min())
+	hi := JumpTarget(n, iptr, width.Max())
+	out := make([]int, 0, hi-lo+1)
+	for to := lo; to <= hi; to++ {
+		if to == iptr+1 && to < n {
+			continue // only an offset of 0 could land here, and it skips
+		}
+		out = append(out, to)
+	}
+	return out
+}
+
+// CallTarget returns where an OpCall at iptr calling label enters its
+// subroutine, which is also where an OpJumpToLabel jumps to. The label is
+// searched for after iptr, wrapping around to the start of the code.
+func CallTarget(labels *vm.LabelIndex, iptr int, label vm.Value) (to int, ok bool) {
+	pos, ok := labels.Find(label, iptr+1)
+	if !ok {
+		return 0, false
+	}
+	return pos + 1, true
+}
+
+// block returns the ID of the block that starts at or contains iptr.
+func (g *Graph) block(iptr int) int {
+	if iptr >= len(g.Code) {
+		return Exit
+	}
+	return g.blockAt[iptr]
+}
+
+func (g *Graph) addEdge(e Edge) {
+	for _, have := range g.Edges {
+		if have == e {
+			return
+		}
+	}
+	g.Edges = append(g.Edges, e)
+}
+
+// BlockAt returns the ID of the block that contains the instruction at iptr.
+func (g *Graph) BlockAt(iptr int) (id int, ok bool) {
+	if iptr < 0 || iptr >= len(g.Code) {
+		return 0, false
+	}
+	return g.blockAt[iptr], true
+}
+
+// Succs returns the edges leaving block id.
+func (g *Graph) Succs(id int) []Edge {
+	var out []Edge
+	for _, e := range g.Edges {
+		if e.From == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Preds returns the edges entering block id.
+func (g *Graph) Preds(id int) []Edge {
+	var out []Edge
+	for _, e := range g.Edges {
+		if e.To == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Reachable reports, for each block, whether it can be reached from the
+// start of the script.
+func (g *Graph) Reachable() []bool {
+	seen := make([]bool, len(g.Blocks))
+	if len(g.Blocks) == 0 {
+		return seen
+	}
+	stack := []int{0}
+	seen[0] = true
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, e := range g.Succs(id) {
+			if e.To == Exit || seen[e.To] {
+				continue
+			}
+			seen[e.To] = true
+			stack = append(stack, e.To)
+		}
+	}
+	return seen
+}
@@ -0,0 +1,133 @@
+package cfg
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+var subroutine = []vm.Op{
+	{Type: vm.OpPush, Arg: 1},   // 0
+	{Type: vm.OpCall, Arg: 7},   // 1
+	{Type: vm.OpStore, Arg: 0},  // 2
+	{Type: vm.OpPush, Arg: 1},   // 3
+	{Type: vm.OpJumpIf, Arg: 2}, // 4: jumps past the end
+	{Type: vm.OpLabel, Arg: 7},  // 5
+	{Type: vm.OpReturn, Arg: 1}, // 6
+}
+
+func TestBuild(t *testing.T) {
+	g := Build(subroutine, vm.Width8)
+	assert.Equal(t, []Block{
+		{ID: 0, Start: 0, End: 2},
+		{ID: 1, Start: 2, End: 5},
+		{ID: 2, Start: 5, End: 6},
+		{ID: 3, Start: 6, End: 7},
+	}, g.Blocks)
+	assert.ElementsMatch(t, []Edge{
+		{From: 0, To: 3, Kind: EdgeCall},
+		{From: 0, To: 1, Kind: EdgeFallthrough},
+		{From: 1, To: Exit, Kind: EdgeJump},
+		{From: 1, To: 2, Kind: EdgeFallthrough},
+		{From: 2, To: 3, Kind: EdgeFallthrough},
+		{From: 3, To: Exit, Kind: EdgeFallthrough},
+		{From: 3, To: 1, Kind: EdgeReturn},
+	}, g.Edges)
+	assert.Equal(t, []bool{true, true, true, true}, g.Reachable())
+	id, ok := g.BlockAt(3)
+	assert.True(t, ok)
+	assert.Equal(t, 1, id)
+	assert.ElementsMatch(t, []Edge{
+		{From: 0, To: 1, Kind: EdgeFallthrough},
+		{From: 3, To: 1, Kind: EdgeReturn},
+	}, g.Preds(1))
+}
+
+func TestBuild_CallWrapsAround(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpLabel, Arg: 1},
+		{Type: vm.OpNoop},
+		{Type: vm.OpCall, Arg: 1},
+		{Type: vm.OpCall, Arg: 2}, // undefined label
+		{Type: vm.OpCall, Arg: 1}, // the label cannot be found from the end
+	}
+	g := Build(code, vm.Width8)
+	assert.Contains(t, g.Edges, Edge{From: g.block(2), To: g.block(1), Kind: EdgeCall})
+	for _, e := range g.Succs(g.block(3)) {
+		assert.Equal(t, EdgeFallthrough, e.Kind)
+	}
+	for _, e := range g.Succs(g.block(4)) {
+		assert.Equal(t, EdgeFallthrough, e.Kind)
+	}
+}
+
+func TestJumpTarget(t *testing.T) {
+	tests := []struct {
+		iptr   int
+		offset vm.Value
+		want   int
+	}{
+		{iptr: 2, offset: 0, want: 4},
+		{iptr: 2, offset: 1, want: 4},
+		{iptr: 2, offset: -1, want: 2},
+		{iptr: 2, offset: -3, want: 0},
+		{iptr: 2, offset: -100, want: 0},
+		{iptr: 2, offset: 100, want: 10},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, JumpTarget(10, tt.iptr, tt.offset), "iptr=%d offset=%d", tt.iptr, tt.offset)
+	}
+}
+
+func TestBuild_Jumps(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpJump, Arg: 2},        // 0
+		{Type: vm.OpLabel, Arg: 1},       // 1
+		{Type: vm.OpNoop},                // 2
+		{Type: vm.OpJumpIfZero, Arg: -3}, // 3
+		{Type: vm.OpJumpToLabel, Arg: 1}, // 4
+		{Type: vm.OpJumpToLabel, Arg: 2}, // 5: undefined label
+	}
+	g := Build(code, vm.Width8)
+	assert.Equal(t, []Edge{{From: g.block(0), To: g.block(3), Kind: EdgeJump}}, g.Succs(g.block(0)))
+	assert.ElementsMatch(t, []Edge{
+		{From: g.block(3), To: g.block(1), Kind: EdgeJump},
+		{From: g.block(3), To: g.block(4), Kind: EdgeFallthrough},
+	}, g.Succs(g.block(3)))
+	assert.Equal(t, []Edge{{From: g.block(4), To: g.block(2), Kind: EdgeJump}}, g.Succs(g.block(4)))
+	assert.Equal(t, []Edge{{From: g.block(5), To: Exit, Kind: EdgeFallthrough}}, g.Succs(g.block(5)))
+}
+
+func TestIndirectTargets(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2, 4, 5}, IndirectTargets(5, 2, vm.Width8))
+	assert.Equal(t, []int{0, 1, 2, 3}, IndirectTargets(3, 2, vm.Width8), "the end is reached by clamping")
+	targets := IndirectTargets(300, 150, vm.Width8)
+	assert.Equal(t, 150+int(vm.MinValue)+1, targets[0])
+	assert.Equal(t, 150+int(vm.MaxValue)+1, targets[len(targets)-1])
+	targets = IndirectTargets(300, 150, vm.Width64)
+	assert.Equal(t, 0, targets[0])
+	assert.Equal(t, 300, targets[len(targets)-1])
+
+	g := Build([]vm.Op{{Type: vm.OpPush}, {Type: vm.OpJumpIndirect}, {Type: vm.OpNoop}, {Type: vm.OpNoop}}, vm.Width8)
+	assert.ElementsMatch(t, []Edge{
+		{From: g.block(1), To: g.block(0), Kind: EdgeJump},
+		{From: g.block(1), To: g.block(1), Kind: EdgeJump},
+		{From: g.block(1), To: g.block(3), Kind: EdgeJump},
+		{From: g.block(1), To: Exit, Kind: EdgeJump},
+		{From: g.block(1), To: g.block(2), Kind: EdgeFallthrough},
+	}, g.Succs(g.block(1)))
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Build(subroutine, vm.Width8).WriteDOT(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "digraph cfg {")
+	assert.Contains(t, out, `b0 [label="1: push 1\l2: call 7\l"];`)
+	assert.Contains(t, out, `b3 -> b1 [label="return" color=blue style=dashed];`)
+	assert.Contains(t, out, `b1 -> exit [label="jump" color=darkgreen];`)
+}
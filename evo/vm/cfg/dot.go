@@ -0,0 +1,67 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+)
+
+// WriteDOT writes the graph in the Graphviz DOT language. Instructions are
+// numbered from 1, and blocks that cannot be reached are drawn dashed.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph cfg {")
+	fmt.Fprintln(bw, "\tnode [shape=box fontname=monospace];")
+	reachable := g.Reachable()
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		for i := b.Start; i < b.End; i++ {
+			line := strings.Replace(evo.EncodeLine(g.Code[i]), "\t", " ", -1)
+			fmt.Fprintf(&label, "%d: %s\\l", i+1, line)
+		}
+		style := ""
+		if !reachable[b.ID] {
+			style = " style=dashed fontcolor=gray"
+		}
+		fmt.Fprintf(bw, "\t%s [label=\"%s\"%s];\n", nodeName(b.ID), label.String(), style)
+	}
+	var exit bool
+	for _, e := range g.Edges {
+		exit = exit || e.To == Exit
+	}
+	if exit {
+		fmt.Fprintf(bw, "\t%s [label=\"exit\" shape=oval];\n", nodeName(Exit))
+	}
+	for _, e := range g.Edges {
+		if style := edgeStyle(e.Kind); style != "" {
+			fmt.Fprintf(bw, "\t%s -> %s [%s];\n", nodeName(e.From), nodeName(e.To), style)
+		} else {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", nodeName(e.From), nodeName(e.To))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func nodeName(id int) string {
+	if id == Exit {
+		return "exit"
+	}
+	return fmt.Sprintf("b%d", id)
+}
+
+func edgeStyle(kind EdgeKind) string {
+	switch kind {
+	case EdgeJump:
+		return `label="jump" color=darkgreen`
+	case EdgeCall:
+		return `label="call" color=blue`
+	case EdgeReturn:
+		return `label="return" color=blue style=dashed`
+	default:
+		return ""
+	}
+}
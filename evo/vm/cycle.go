@@ -0,0 +1,86 @@
+package vm
+
+import "encoding/binary"
+
+// Loop describes a machine state that repeated during a run. Since the VM is
+// deterministic, a program that revisits a state loops forever.
+type Loop struct {
+	// Start is the iteration at which the repeated state was first seen.
+	Start int
+	// Length is the number of iterations in one pass of the loop.
+	Length int
+	// Iptr is the instruction pointer of the repeated state.
+	Iptr int
+}
+
+// RuntimeWithCycleDetection returns hooks that interrupt a run as soon as the
+// machine state repeats, and report the loop in RunResult.Loop.
+//
+// The state is fingerprinted by its instruction pointer, live stack frames
+// and registers, and also by its code if the runtime is SelfModifying. Gas
+// is ignored, so a metered loop is detected before it runs out of gas.
+// Syscalls are assumed to depend only on the machine state.
+//
+// The hooks keep the states seen in the current run, which starts over when
+// a run reaches them with no iterations yet. A runtime that uses them must
+// not run concurrently; give each concurrent run its own hooks instead.
+func RuntimeWithCycleDetection() RuntimeHookConfig {
+	var seen map[string]int
+	return RuntimeHookConfig{
+		RuntimeHookBeforeStep: []RuntimeHandler{
+			func(r *Runtime, state *State, result *RunResult) (ok bool) {
+				if seen == nil || result.Iterations == 0 {
+					seen = make(map[string]int)
+				}
+				key := fingerprint(state, r.SelfModifying)
+				if start, ok := seen[key]; ok {
+					result.Loop = &Loop{
+						Start:  start,
+						Length: result.Iterations - start,
+						Iptr:   state.Script.Iptr,
+					}
+					return false
+				}
+				seen[key] = result.Iterations
+				return true
+			},
+		},
+	}
+}
+
+// fingerprint encodes the parts of state that determine how it executes.
+// The code is only included if withCode is set.
+func fingerprint(state *State, withCode bool) string {
+	buf := make([]byte, 0, 64)
+	var tmp [binary.MaxVarintLen64]byte
+	putInt := func(x int64) {
+		buf = append(buf, tmp[:binary.PutVarint(tmp[:], x)]...)
+	}
+	putInt(int64(state.Script.Iptr))
+	frames := state.Stack.Frames()
+	if len(frames) == 0 {
+		// an empty stack behaves like an empty base frame
+		frames = []StackFrame{{}}
+	}
+	putInt(int64(len(frames)))
+	for _, frame := range frames {
+		values := frame.Values()
+		putInt(int64(frame.Return))
+		putInt(int64(len(values)))
+		for _, val := range values {
+			putInt(int64(val))
+		}
+	}
+	putInt(int64(len(state.Registers)))
+	for _, val := range state.Registers {
+		putInt(int64(val))
+	}
+	if withCode {
+		putInt(int64(len(state.Script.Code)))
+		for _, instr := range state.Script.Code {
+			putInt(int64(instr.Type))
+			putInt(int64(instr.Arg))
+		}
+	}
+	return string(buf)
+}
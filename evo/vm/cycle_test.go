@@ -0,0 +1,76 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestRuntimeWithCycleDetection(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []vm.Op
+		wantLoop *vm.Loop
+	}{
+		{
+			name:     "terminates",
+			code:     countdown,
+			wantLoop: nil,
+		},
+		{
+			name: "tight loop",
+			code: []vm.Op{
+				{Type: vm.OpLabel, Arg: 1},
+				{Type: vm.OpPush, Arg: 1},
+				{Type: vm.OpJumpIf, Arg: -3},
+			},
+			wantLoop: &vm.Loop{Start: 0, Length: 3, Iptr: 0},
+		},
+		{
+			name: "counter wraps around",
+			code: []vm.Op{
+				{Type: vm.OpPush},
+				{Type: vm.OpInc},
+				{Type: vm.OpPush, Arg: 1},
+				{Type: vm.OpJumpIf, Arg: -3},
+			},
+			wantLoop: &vm.Loop{Start: 1, Length: 3 * 256, Iptr: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := (&vm.Runtime{
+				Impl:  impl.Map,
+				Hooks: vm.RuntimeWithMaxIterations(10000),
+			}).AddHook(vm.RuntimeWithCycleDetection())
+			state := vm.State{
+				Script:    vm.Script{Code: tt.code},
+				Registers: make(vm.Register, 2),
+			}
+			result := runtime.Run(&state)
+			assert.Equal(t, tt.wantLoop, result.Loop)
+			assert.Equal(t, tt.wantLoop != nil, result.Interrupted)
+			if tt.wantLoop != nil {
+				assert.Equal(t, tt.wantLoop.Start+tt.wantLoop.Length, result.Iterations)
+			}
+		})
+	}
+}
+
+func TestRuntimeWithCycleDetection_Rerun(t *testing.T) {
+	runtime := (&vm.Runtime{Impl: impl.Map}).AddHook(vm.RuntimeWithCycleDetection())
+	code := []vm.Op{
+		{Type: vm.OpLabel, Arg: 1},
+		{Type: vm.OpPush, Arg: 1},
+		{Type: vm.OpJumpIf, Arg: -3},
+	}
+	// each run starts with no states seen
+	for i := 0; i < 2; i++ {
+		state := vm.State{Script: vm.Script{Code: code}}
+		result := runtime.Run(&state)
+		assert.Equal(t, &vm.Loop{Start: 0, Length: 3, Iptr: 0}, result.Loop)
+	}
+}
@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// PointKind ...
+type PointKind int
+
+const (
+	// PointLine stops before the instruction at Point.Arg is executed.
+	PointLine PointKind = iota
+	// PointLabel stops before an OpLabel with the value Point.Arg is executed.
+	// Calls and jumps to the label enter after it, so it also stops before
+	// the instruction after the label when it is reached that way.
+	PointLabel
+	// PointRegister stops after register Point.Arg changes.
+	PointRegister
+	// PointStack stops after the live stack values change.
+	PointStack
+)
+
+// Point is a breakpoint or watchpoint.
+type Point struct {
+	ID   int
+	Kind PointKind
+	Arg  int
+}
+
+func (p Point) String() string {
+	switch p.Kind {
+	case PointLine:
+		return fmt.Sprintf("breakpoint %d at line %d", p.ID, p.Arg+1)
+	case PointLabel:
+		return fmt.Sprintf("breakpoint %d at label %d", p.ID, p.Arg)
+	case PointRegister:
+		return fmt.Sprintf("watchpoint %d on register %d", p.ID, p.Arg)
+	case PointStack:
+		return fmt.Sprintf("watchpoint %d on stack", p.ID)
+	default:
+		return "point " + strconv.Itoa(p.ID)
+	}
+}
+
+// StopReason ...
+type StopReason int
+
+const (
+	// StopHalted means the program halted.
+	StopHalted StopReason = iota
+	// StopInterrupted means a hook other than the debugger's interrupted the run.
+	StopInterrupted
+	// StopBreakpoint means a breakpoint was reached.
+	StopBreakpoint
+	// StopWatchpoint means a watched value changed.
+	StopWatchpoint
+)
+
+// Stop describes why the debugger stopped.
+type Stop struct {
+	Reason StopReason
+	// Point is the breakpoint or watchpoint that stopped execution.
+	Point Point
+	// Iptr is the instruction pointer at the stop.
+	Iptr int
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case StopHalted:
+		return "halted"
+	case StopInterrupted:
+		return "interrupted"
+	default:
+		return "stopped at " + s.Point.String()
+	}
+}
+
+// Debugger stops a run at breakpoints and watchpoints. It is attached to a
+// runtime through hooks and follows a single State.
+//
+// A run never stops at a breakpoint before executing its first instruction,
+// so continuing from a breakpoint makes progress.
+type Debugger struct {
+	points    map[int]Point
+	nextID    int
+	stop      *Stop
+	registers Register
+	stack     Stack
+	// last is the position of the most recently executed instruction, or
+	// -1 before the first.
+	last int
+}
+
+// NewDebugger ...
+func NewDebugger() *Debugger {
+	return &Debugger{points: make(map[int]Point), last: -1}
+}
+
+// Attach installs the debugger's hooks into r.
+func (d *Debugger) Attach(r *Runtime) *Runtime {
+	return r.AddHook(RuntimeHookConfig{
+		RuntimeHookBeforeStep: []RuntimeHandler{d.beforeStep},
+		RuntimeHookAfterStep:  []RuntimeHandler{d.afterStep},
+	})
+}
+
+// Break adds a breakpoint before the instruction at iptr.
+func (d *Debugger) Break(iptr int) Point {
+	return d.add(PointLine, iptr)
+}
+
+// BreakLabel adds a breakpoint before any label with value label.
+func (d *Debugger) BreakLabel(label Value) Point {
+	return d.add(PointLabel, int(label))
+}
+
+// WatchRegister adds a watchpoint on register i.
+func (d *Debugger) WatchRegister(i int) Point {
+	return d.add(PointRegister, i)
+}
+
+// WatchStack adds a watchpoint on the stack.
+func (d *Debugger) WatchStack() Point {
+	return d.add(PointStack, 0)
+}
+
+// Delete removes the point with the given ID.
+func (d *Debugger) Delete(id int) (ok bool) {
+	_, ok = d.points[id]
+	delete(d.points, id)
+	return ok
+}
+
+// DeleteAll removes all points.
+func (d *Debugger) DeleteAll() {
+	d.points = make(map[int]Point)
+}
+
+// Points returns the points ordered by ID.
+func (d *Debugger) Points() []Point {
+	out := make([]Point, 0, len(d.points))
+	for _, p := range d.points {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Reset synchronizes the debugger with state after it was changed outside
+// of a run of the attached runtime, for example by rewinding or replacing
+// it. Watchpoints then compare against the values of state.
+func (d *Debugger) Reset(state *State) {
+	d.stop = nil
+	d.last = -1
+	d.observe(state)
+}
+
+// Continue runs state until it reaches a breakpoint or watchpoint, or the
+// run ends. r must have the debugger attached.
+func (d *Debugger) Continue(r *Runtime, state *State) (Stop, RunResult) {
+	d.stop = nil
+	result := r.Run(state)
+	return d.Stopped(state, result), result
+}
+
+// Stopped reports why a run of state that ended with result stopped, and
+// clears the pending stop.
+func (d *Debugger) Stopped(state *State, result RunResult) Stop {
+	stop := d.stop
+	d.stop = nil
+	if stop != nil {
+		return *stop
+	}
+	reason := StopHalted
+	if result.Interrupted {
+		reason = StopInterrupted
+	}
+	return Stop{Reason: reason, Iptr: state.Script.Iptr}
+}
+
+func (d *Debugger) add(kind PointKind, arg int) Point {
+	d.nextID++
+	p := Point{ID: d.nextID, Kind: kind, Arg: arg}
+	d.points[p.ID] = p
+	return p
+}
+
+func (d *Debugger) beforeStep(r *Runtime, state *State, result *RunResult) (ok bool) {
+	if result.Iterations == 0 {
+		d.observe(state)
+		return true
+	}
+	p, ok := d.BreakpointAt(state)
+	if !ok {
+		return true
+	}
+	d.stop = &Stop{Reason: StopBreakpoint, Point: p, Iptr: state.Script.Iptr}
+	return false
+}
+
+// BreakpointAt returns the breakpoint that matches the next instruction of
+// state, if any.
+func (d *Debugger) BreakpointAt(state *State) (p Point, ok bool) {
+	iptr := state.Script.Iptr
+	next, ok := state.Script.Peek()
+	if !ok {
+		return Point{}, false
+	}
+	// entered is the label the state just jumped past, if any; falling
+	// through the label has already stopped before it
+	var entered *Op
+	if iptr > 0 && d.last != iptr-1 && state.Script.Code[iptr-1].Type == OpLabel {
+		entered = &state.Script.Code[iptr-1]
+	}
+	for _, p := range d.Points() {
+		hit := (p.Kind == PointLine && p.Arg == iptr) ||
+			(p.Kind == PointLabel && next.Type == OpLabel && p.Arg == int(next.Arg)) ||
+			(p.Kind == PointLabel && entered != nil && p.Arg == int(entered.Arg))
+		if hit {
+			return p, true
+		}
+	}
+	return Point{}, false
+}
+
+func (d *Debugger) afterStep(r *Runtime, state *State, result *RunResult) (ok bool) {
+	defer d.observe(state)
+	d.last = result.Last.Before
+	for _, p := range d.Points() {
+		var hit bool
+		switch p.Kind {
+		case PointRegister:
+			before, _ := d.registers.Load(p.Arg)
+			after, _ := state.Registers.Load(p.Arg)
+			hit = before != after
+		case PointStack:
+			hit = !stackEqual(&d.stack, &state.Stack)
+		}
+		if hit {
+			d.stop = &Stop{Reason: StopWatchpoint, Point: p, Iptr: state.Script.Iptr}
+			return false
+		}
+	}
+	return true
+}
+
+// observe records the values that watchpoints compare against.
+func (d *Debugger) observe(state *State) {
+	d.registers = append(d.registers[:0], state.Registers...)
+	d.stack = state.Stack
+}
+
+// stackEqual compares the live frames and values of two stacks.
+func stackEqual(a, b *Stack) bool {
+	af, bf := a.Frames(), b.Frames()
+	if len(af) != len(bf) {
+		return false
+	}
+	for i := range af {
+		if af[i].Return != bf[i].Return {
+			return false
+		}
+		av, bv := af[i].Values(), bf[i].Values()
+		if len(av) != len(bv) {
+			return false
+		}
+		for j := range av {
+			if av[j] != bv[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
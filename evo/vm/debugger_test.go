@@ -0,0 +1,128 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+// countdown stores 3, 2, 1, 0 in register 0.
+var countdown = []vm.Op{
+	vm.Op{Type: vm.OpPush, Arg: 3},
+	vm.Op{Type: vm.OpLabel, Arg: 1},
+	vm.Op{Type: vm.OpStore, Arg: 0},
+	vm.Op{Type: vm.OpDec},
+	vm.Op{Type: vm.OpDup},
+	vm.Op{Type: vm.OpNot},
+	vm.Op{Type: vm.OpJumpIf, Arg: 2},
+	vm.Op{Type: vm.OpPush, Arg: 1},
+	vm.Op{Type: vm.OpJumpIf, Arg: -8},
+	vm.Op{Type: vm.OpStore, Arg: 0},
+}
+
+func newDebugSession() (*vm.Debugger, *vm.Runtime, *vm.State) {
+	debugger := vm.NewDebugger()
+	runtime := debugger.Attach(&vm.Runtime{
+		Impl:  impl.Map,
+		Hooks: vm.RuntimeWithMaxIterations(1000),
+	})
+	state := &vm.State{
+		Script:    vm.Script{Code: countdown},
+		Registers: make(vm.Register, 2),
+	}
+	return debugger, runtime, state
+}
+
+func TestDebugger_Break(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	bp := debugger.Break(3)
+	var values []vm.Value
+	for {
+		stop, _ := debugger.Continue(runtime, state)
+		if stop.Reason != vm.StopBreakpoint {
+			assert.Equal(t, vm.StopHalted, stop.Reason)
+			break
+		}
+		assert.Equal(t, bp, stop.Point)
+		assert.Equal(t, 3, stop.Iptr)
+		values = append(values, state.Registers[0])
+	}
+	assert.Equal(t, []vm.Value{3, 2, 1}, values)
+}
+
+func TestDebugger_BreakLabel(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	bp := debugger.BreakLabel(1)
+	stop, _ := debugger.Continue(runtime, state)
+	assert.Equal(t, vm.Stop{Reason: vm.StopBreakpoint, Point: bp, Iptr: 1}, stop)
+	debugger.Delete(bp.ID)
+	stop, _ = debugger.Continue(runtime, state)
+	assert.Equal(t, vm.StopHalted, stop.Reason)
+	assert.Equal(t, vm.Value(0), state.Registers[0])
+}
+
+func TestDebugger_BreakLabel_Call(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	state.Script.Code = []vm.Op{
+		vm.Op{Type: vm.OpCall, Arg: 1},
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpJumpIf, Arg: 2},
+		vm.Op{Type: vm.OpLabel, Arg: 1},
+		vm.Op{Type: vm.OpReturn},
+	}
+	bp := debugger.BreakLabel(1)
+	// the call enters the subroutine after its label
+	stop, _ := debugger.Continue(runtime, state)
+	assert.Equal(t, vm.Stop{Reason: vm.StopBreakpoint, Point: bp, Iptr: 4}, stop)
+	stop, _ = debugger.Continue(runtime, state)
+	assert.Equal(t, vm.StopHalted, stop.Reason)
+}
+
+func TestDebugger_WatchRegister(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	wp := debugger.WatchRegister(0)
+	debugger.WatchRegister(1)
+	var values []vm.Value
+	for {
+		stop, _ := debugger.Continue(runtime, state)
+		if stop.Reason != vm.StopWatchpoint {
+			break
+		}
+		assert.Equal(t, wp, stop.Point)
+		values = append(values, state.Registers[0])
+	}
+	assert.Equal(t, []vm.Value{3, 2, 1, 0}, values)
+}
+
+func TestDebugger_Reset(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	wp := debugger.WatchRegister(0)
+	stop, _ := debugger.Continue(runtime, state)
+	assert.Equal(t, wp, stop.Point)
+	// the state changes outside of a run, as loading a session does
+	state.Registers[0] = 9
+	debugger.Reset(state)
+	var result vm.RunResult
+	assert.True(t, runtime.Step(state, &result), "dec does not change register 0")
+	assert.Equal(t, vm.StopHalted, debugger.Stopped(state, result).Reason)
+	// without a reset, the next step reports the outside change
+	state.Registers[0] = 7
+	assert.False(t, runtime.Step(state, &result))
+	assert.Equal(t, vm.StopWatchpoint, debugger.Stopped(state, result).Reason)
+}
+
+func TestDebugger_WatchStack(t *testing.T) {
+	debugger, runtime, state := newDebugSession()
+	debugger.WatchStack()
+	stop, _ := debugger.Continue(runtime, state)
+	assert.Equal(t, vm.StopWatchpoint, stop.Reason)
+	assert.Equal(t, 1, stop.Iptr)
+	stop, _ = debugger.Continue(runtime, state)
+	assert.Equal(t, vm.StopWatchpoint, stop.Reason)
+	assert.Equal(t, 4, stop.Iptr, "label and store leave the stack alone")
+	debugger.DeleteAll()
+	assert.Empty(t, debugger.Points())
+}
@@ -0,0 +1,84 @@
+package vm
+
+// Flow describes where execution continues after an instruction.
+type Flow int
+
+const (
+	// FlowNext continues with the next instruction.
+	FlowNext Flow = iota
+	// FlowBranch pops a condition and jumps by the instruction's arg if it
+	// is true, as OpJumpIf does.
+	FlowBranch
+	// FlowBranchIfZero pops a condition and jumps by the instruction's arg
+	// if it is false, as OpJumpIfZero does.
+	FlowBranchIfZero
+	// FlowJump jumps by the instruction's arg, as OpJump does.
+	FlowJump
+	// FlowJumpToLabel jumps past the next matching label, as OpJumpToLabel
+	// does. It falls through if there is no such label.
+	FlowJumpToLabel
+	// FlowIndirect pops an offset and jumps by it, as OpJumpIndirect does.
+	FlowIndirect
+	// FlowCall enters the next matching label in a new frame.
+	FlowCall
+	// FlowReturn leaves the current frame.
+	FlowReturn
+)
+
+// Access describes how an instruction uses the register its arg names.
+type Access int
+
+const (
+	// AccessNone means the instruction does not use registers.
+	AccessNone Access = iota
+	// AccessRead means the instruction reads the register.
+	AccessRead
+	// AccessWrite means the instruction writes the register.
+	AccessWrite
+)
+
+// Effect describes what the implementation of an opcode does to the machine
+// when it does not fault, for tools that reason about scripts without
+// running them.
+type Effect struct {
+	// In is the number of values the instruction needs on the current
+	// frame, and Out is the number of values it leaves in their place.
+	In, Out int
+	// ArgIn is set if the arg adds to In, as it does for OpPick. A negative
+	// arg always underflows.
+	ArgIn bool
+	Flow  Flow
+	// Register is how the instruction uses the register its arg names.
+	Register Access
+	// Code is how the instruction uses the script's code. Tools that
+	// assume the code does not change must not rely on the analysis of
+	// scripts that write it.
+	Code Access
+	// Indirect is the effect when the arg is not a register. The register
+	// is then named by the top value of the frame.
+	Indirect *Effect
+}
+
+// EffectOf returns the effect of instr on a machine with the given number of
+// registers. ok is false if the opcode is not registered or its effect is
+// unknown.
+func EffectOf(instr Op, registers int) (e Effect, ok bool) {
+	info, ok := instr.Type.Info()
+	if !ok || info.Effect == nil {
+		return Effect{}, false
+	}
+	e = *info.Effect
+	if e.Indirect != nil && (instr.Arg < 0 || int(instr.Arg) >= registers) {
+		e = *e.Indirect
+	}
+	if e.ArgIn {
+		if instr.Arg < 0 || instr.Arg >= FrameSize {
+			// no frame holds that many values, and e.In must not overflow
+			e.In = FrameSize + 1
+		} else {
+			e.In += int(instr.Arg) + 1
+		}
+		e.Out += e.In
+	}
+	return e, true
+}
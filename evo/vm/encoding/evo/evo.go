@@ -12,7 +12,10 @@ import (
 	"github.com/jncornett/beans-engine/evo/vm"
 )
 
-// OpCodes ...
+// OpCodes maps the lowercase names of the built-in opcodes to their codes.
+//
+// Deprecated: OpCodes does not include the opcodes added with vm.RegisterOp.
+// Use vm.LookupOp instead.
 var OpCodes = (func() map[string]vm.OpCode {
 	out := make(map[string]vm.OpCode)
 	for _, op := range vm.OpCodes {
@@ -42,6 +45,15 @@ func Unmarshal(p []byte) ([]vm.Op, error) {
 	return out, nil
 }
 
+// UnmarshalLines is like Unmarshal, but also returns the line number of
+// each instruction.
+func UnmarshalLines(p []byte) (code []vm.Op, lines []int, err error) {
+	if err := NewDecoder(bytes.NewReader(p)).DecodeLines(&code, &lines); err != nil {
+		return nil, nil, err
+	}
+	return code, lines, nil
+}
+
 var bases = map[string]int{
 	"x": 16,
 	"o": 8,
@@ -64,7 +76,7 @@ func parseValue(s string) (vm.Value, error) {
 			break
 		}
 	}
-	v, err := strconv.ParseInt(s, base, 8)
+	v, err := strconv.ParseInt(s, base, 64)
 	if err != nil {
 		return 0, err
 	}
@@ -73,7 +85,7 @@ func parseValue(s string) (vm.Value, error) {
 
 func parseOp(opName string, fields []string) (vm.Op, error) {
 	var arg vm.Value
-	op, ok := OpCodes[opName]
+	op, ok := vm.LookupOp(opName)
 	if !ok {
 		return vm.Op{}, fmt.Errorf("unknown opcode: %q", opName)
 	}
@@ -118,6 +130,13 @@ func DecodeArgs(fields ...string) (vm.Op, error) {
 
 // Decode ...
 func (dec *Decoder) Decode(out *[]vm.Op) error {
+	return dec.DecodeLines(out, nil)
+}
+
+// DecodeLines is like Decode, but also appends the line number of each
+// instruction to lines if it is not nil. Lines are numbered from 1 and
+// include blank lines and comments.
+func (dec *Decoder) DecodeLines(out *[]vm.Op, lines *[]int) error {
 	i := 0
 	for dec.scan.Scan() {
 		i++
@@ -129,6 +148,9 @@ func (dec *Decoder) Decode(out *[]vm.Op) error {
 			continue
 		}
 		*out = append(*out, vm.Op(op))
+		if lines != nil {
+			*lines = append(*lines, i)
+		}
 	}
 	if err := dec.scan.Err(); err != nil {
 		return err
@@ -1,6 +1,7 @@
 package evo
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,3 +25,26 @@ func TestMarshalUnmarshal(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, code, got)
 }
+
+func TestDecodeLine_Arithmetic(t *testing.T) {
+	for _, name := range []string{"add", "sub", "mul", "div", "mod", "neg", "and", "or", "xor", "shl", "shr"} {
+		t.Run(name, func(t *testing.T) {
+			op, ok, err := DecodeLine(name)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, name, strings.ToLower(op.Type.String()))
+		})
+	}
+}
+
+func TestUnmarshalLines(t *testing.T) {
+	src := "; countdown\npush 3\n\nlabel 1\n  ; body\nstore 0\n"
+	code, lines, err := UnmarshalLines([]byte(src))
+	require.NoError(t, err)
+	assert.Equal(t, []vm.Op{
+		{Type: vm.OpPush, Arg: 3},
+		{Type: vm.OpLabel, Arg: 1},
+		{Type: vm.OpStore, Arg: 0},
+	}, code)
+	assert.Equal(t, []int{2, 4, 6}, lines)
+}
@@ -28,15 +28,33 @@ func (f VersionField) String() string {
 }
 
 // Version ...
-var Version = VersionField{0, 0, 1, 0}
+var Version = VersionField{0, 0, 2, 0}
+
+// version1 is the version before the width field was added. Its args are
+// encoded in 8 bits.
+var version1 = VersionField{0, 0, 1, 0}
+
+// WidthField is the number of bits each op's arg is encoded in.
+type WidthField uint8
+
+// Width returns the width with the field's number of bits.
+func (f WidthField) Width() (w vm.Width, ok bool) {
+	for _, w := range vm.Widths {
+		if w.Bits() == uint(f) {
+			return w, true
+		}
+	}
+	return 0, false
+}
 
 // LengthField ...
 type LengthField uint64
 
-// OpField ...
+// OpField is an op as it is encoded: its type in one byte, followed by its
+// arg in as many bits as the WidthField gives.
 type OpField struct {
 	Type int8
-	Arg  int8
+	Arg  int64
 }
 
 // DefaultByteOrder ...
@@ -46,6 +64,8 @@ var DefaultByteOrder = binary.LittleEndian
 type Decoder struct {
 	r         io.Reader
 	ByteOrder binary.ByteOrder
+	// Width is the width the most recently decoded script was encoded with.
+	Width vm.Width
 }
 
 // NewDecoder ...
@@ -75,16 +95,17 @@ func (dec *Decoder) Decode(out *[]vm.Op) error {
 	if err := dec.read(&version); err != nil {
 		return fmt.Errorf("invalid version field: %w", err)
 	}
-	if !bytes.Equal(Version[:], version[:]) {
-		return fmt.Errorf("version mismatch: want %v, got %v", Version, version)
+	width, err := dec.readWidth(version)
+	if err != nil {
+		return err
 	}
 	var length LengthField
 	if err := dec.read(&length); err != nil {
 		return fmt.Errorf("invalid length field: %w", err)
 	}
 	for i := 0; LengthField(i) < length; i++ {
-		var op OpField
-		if err := dec.read(&op); err != nil {
+		op, err := dec.readOp(width)
+		if err != nil {
 			return fmt.Errorf("at op %d: %w", i, err)
 		}
 		*out = append(*out, vm.Op{
@@ -92,9 +113,53 @@ func (dec *Decoder) Decode(out *[]vm.Op) error {
 			Arg:  vm.Value(op.Arg),
 		})
 	}
+	dec.Width = width
 	return nil
 }
 
+// readWidth reads the width field that follows a header of the given version.
+func (dec *Decoder) readWidth(version VersionField) (vm.Width, error) {
+	switch version {
+	case Version:
+	case version1:
+		return vm.Width8, nil
+	default:
+		return 0, fmt.Errorf("version mismatch: want %v, got %v", Version, version)
+	}
+	var bits WidthField
+	if err := dec.read(&bits); err != nil {
+		return 0, fmt.Errorf("invalid width field: %w", err)
+	}
+	width, ok := bits.Width()
+	if !ok {
+		return 0, fmt.Errorf("unsupported width: %d bits", bits)
+	}
+	return width, nil
+}
+
+func (dec *Decoder) readOp(width vm.Width) (op OpField, err error) {
+	if err := dec.read(&op.Type); err != nil {
+		return OpField{}, err
+	}
+	switch width {
+	case vm.Width8:
+		var arg int8
+		err = dec.read(&arg)
+		op.Arg = int64(arg)
+	case vm.Width16:
+		var arg int16
+		err = dec.read(&arg)
+		op.Arg = int64(arg)
+	case vm.Width32:
+		var arg int32
+		err = dec.read(&arg)
+		op.Arg = int64(arg)
+	default:
+		err = dec.read(&op.Arg)
+	}
+	return op, err
+}
+
 func (dec *Decoder) read(out interface{}) error {
 	return binary.Read(dec.r, dec.ByteOrder, out)
 }
@@ -103,6 +168,9 @@ func (dec *Decoder) read(out interface{}) error {
 type Encoder struct {
 	w         io.Writer
 	ByteOrder binary.ByteOrder
+	// Width is the width args are encoded in. Encode fails if an arg does
+	// not fit in it.
+	Width vm.Width
 }
 
 // NewEncoder ...
@@ -110,10 +178,17 @@ func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{w: w, ByteOrder: DefaultByteOrder}
 }
 
-// Marshal ...
+// Marshal encodes args in 8 bits, the width of a default runtime.
 func Marshal(in []vm.Op) ([]byte, error) {
+	return MarshalWidth(in, vm.Width8)
+}
+
+// MarshalWidth is like Marshal, but encodes args in the given width.
+func MarshalWidth(in []vm.Op, width vm.Width) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := NewEncoder(&buf).Encode(in); err != nil {
+	enc := NewEncoder(&buf)
+	enc.Width = width
+	if err := enc.Encode(in); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -121,12 +196,25 @@ func Marshal(in []vm.Op) ([]byte, error) {
 
 // Encode ...
 func (enc *Encoder) Encode(in []vm.Op) error {
+	width := enc.Width
+	if !width.Valid() {
+		return fmt.Errorf("unsupported width: %v", width)
+	}
+	for i, op := range in {
+		if !width.Contains(op.Arg) {
+			return fmt.Errorf("at op %d: arg %d does not fit in %v", i, op.Arg, width)
+		}
+	}
 	if err := enc.write(&Magic); err != nil {
 		return fmt.Errorf("failed to write magic field: %w", err)
 	}
 	if err := enc.write(&Version); err != nil {
 		return fmt.Errorf("failed to write version field: %w", err)
 	}
+	bits := WidthField(width.Bits())
+	if err := enc.write(&bits); err != nil {
+		return fmt.Errorf("failed to write width field: %w", err)
+	}
 	length := LengthField(len(in))
 	if err := enc.write(&length); err != nil {
 		return fmt.Errorf("failed to write length field: %w", err)
@@ -134,15 +222,31 @@ func (enc *Encoder) Encode(in []vm.Op) error {
 	for i, op := range in {
 		field := OpField{
 			Type: int8(op.Type),
-			Arg:  int8(op.Arg),
+			Arg:  int64(op.Arg),
 		}
-		if err := enc.write(&field); err != nil {
+		if err := enc.writeOp(width, field); err != nil {
 			return fmt.Errorf("at op %d: %w", i, err)
 		}
 	}
 	return nil
 }
 
+func (enc *Encoder) writeOp(width vm.Width, op OpField) error {
+	if err := enc.write(op.Type); err != nil {
+		return err
+	}
+	switch width {
+	case vm.Width8:
+		return enc.write(int8(op.Arg))
+	case vm.Width16:
+		return enc.write(int16(op.Arg))
+	case vm.Width32:
+		return enc.write(int32(op.Arg))
+	default:
+		return enc.write(op.Arg)
+	}
+}
+
 func (enc *Encoder) write(in interface{}) error {
 	return binary.Write(enc.w, enc.ByteOrder, in)
 }
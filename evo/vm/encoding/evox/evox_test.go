@@ -58,3 +58,44 @@ func TestEncodeDecode(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, code2, gotCode2)
 }
+
+func TestMarshalUnmarshal_Width(t *testing.T) {
+	for _, width := range vm.Widths {
+		code := []vm.Op{
+			{Type: vm.OpPush, Arg: 1},
+			{Type: vm.OpPush, Arg: width.Min()},
+		}
+		b, err := MarshalWidth(code, width)
+		require.NoError(t, err)
+		dec := NewDecoder(bytes.NewReader(b))
+		var got []vm.Op
+		require.NoError(t, dec.Decode(&got))
+		assert.Equal(t, code, got)
+		assert.Equal(t, width, dec.Width, "the chosen width is recorded even if a narrower one holds every arg")
+		// the header is followed by a type byte and an arg per op
+		assert.Len(t, b, 4+4+1+8+2*(1+int(width.Bits()/8)))
+	}
+}
+
+func TestMarshal_ArgTooWide(t *testing.T) {
+	code := []vm.Op{{Type: vm.OpPush, Arg: 300}}
+	_, err := Marshal(code)
+	assert.Error(t, err)
+	_, err = MarshalWidth(code, vm.Width16)
+	assert.NoError(t, err)
+}
+
+func TestUnmarshal_Version1(t *testing.T) {
+	b := []byte{
+		4, 3, 2, 1, // magic
+		0, 0, 1, 0, // version
+		2, 0, 0, 0, 0, 0, 0, 0, // length
+		byte(vm.OpPush), 0xff,
+		byte(vm.OpNoop), 0,
+	}
+	dec := NewDecoder(bytes.NewReader(b))
+	var got []vm.Op
+	require.NoError(t, dec.Decode(&got))
+	assert.Equal(t, []vm.Op{{Type: vm.OpPush, Arg: -1}, {Type: vm.OpNoop}}, got)
+	assert.Equal(t, vm.Width8, dec.Width)
+}
@@ -0,0 +1,4 @@
+package vm
+
+// SaveOps exposes saveOps to the external tests.
+var SaveOps = saveOps
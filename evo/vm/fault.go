@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FaultKind ...
+type FaultKind int
+
+const (
+	// FaultNone ...
+	FaultNone FaultKind = iota
+	// FaultStackOverflow is raised when pushing onto a full frame.
+	FaultStackOverflow
+	// FaultStackUnderflow is raised when popping from an empty frame.
+	FaultStackUnderflow
+	// FaultRegisterOutOfRange is raised when loading or storing a register that does not exist.
+	FaultRegisterOutOfRange
+	// FaultIllegalOp is raised when executing an opcode that is missing from Impl.
+	FaultIllegalOp
+	// FaultUnknownSyscall is raised when OpSyscall names an unregistered syscall.
+	FaultUnknownSyscall
+	// FaultUndefinedLabel is raised when OpCall names a label that does not exist.
+	FaultUndefinedLabel
+	// FaultFrameOverflow is raised when calling with MaxFrames frames on the stack.
+	FaultFrameOverflow
+	// FaultFrameUnderflow is raised when returning without a caller frame.
+	FaultFrameUnderflow
+	// FaultDivideByZero is raised when dividing by zero.
+	FaultDivideByZero
+	// FaultCodeOutOfRange is raised when a self-modifying instruction
+	// addresses a position outside the script, or would grow the script
+	// past Runtime.MaxCodeLen.
+	FaultCodeOutOfRange
+	// FaultMax ...
+	FaultMax
+)
+
+func (kind FaultKind) String() string {
+	switch kind {
+	case FaultNone:
+		return "None"
+	case FaultStackOverflow:
+		return "StackOverflow"
+	case FaultStackUnderflow:
+		return "StackUnderflow"
+	case FaultRegisterOutOfRange:
+		return "RegisterOutOfRange"
+	case FaultIllegalOp:
+		return "IllegalOp"
+	case FaultUnknownSyscall:
+		return "UnknownSyscall"
+	case FaultUndefinedLabel:
+		return "UndefinedLabel"
+	case FaultFrameOverflow:
+		return "FrameOverflow"
+	case FaultFrameUnderflow:
+		return "FrameUnderflow"
+	case FaultDivideByZero:
+		return "DivideByZero"
+	case FaultCodeOutOfRange:
+		return "CodeOutOfRange"
+	case FaultMax:
+		return "Max"
+	default:
+		return "FaultKind(" + strconv.Itoa(int(kind)) + ")"
+	}
+}
+
+// Fault describes an error raised while executing an instruction.
+type Fault struct {
+	Kind FaultKind
+	Iptr int
+	Op   Op
+}
+
+func (f Fault) Error() string {
+	return fmt.Sprintf("%v at %d (%v %d)", f.Kind, f.Iptr, f.Op.Type, f.Op.Arg)
+}
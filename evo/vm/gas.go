@@ -0,0 +1,31 @@
+package vm
+
+// DefaultCost is the gas consumed by opcodes that are missing from a
+// CostTable and have no cost of their own in their OpInfo.
+const DefaultCost = 1
+
+// CostTable maps opcodes to the gas they consume.
+type CostTable map[OpCode]int
+
+// Cost ...
+func (t CostTable) Cost(op OpCode) int {
+	if cost, ok := t[op]; ok {
+		return cost
+	}
+	if info, ok := op.Info(); ok && info.Cost > 0 {
+		return info.Cost
+	}
+	return DefaultCost
+}
+
+// charge deducts cost from the state's gas budget.
+// charge returns false if the budget cannot cover it.
+func charge(state *State, cost int, result *RunResult) (ok bool) {
+	if state.Gas < cost {
+		result.OutOfGas = true
+		return false
+	}
+	state.Gas -= cost
+	result.GasUsed += cost
+	return true
+}
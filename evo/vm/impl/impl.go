@@ -2,82 +2,205 @@ package impl
 
 import "github.com/jncornett/beans-engine/evo/vm"
 
-// OpPush pushes a constant value onto the stack.
+// popValue pops a value from the current frame, faulting if it is empty.
+func popValue(ctx vm.Context) vm.Value {
+	val, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+	}
+	return val
+}
+
+// pushValue pushes a value onto the current frame, faulting if it is full.
+func pushValue(ctx vm.Context, val vm.Value) {
+	if !ctx.Stack().PushValue(val) {
+		ctx.Fault(vm.FaultStackOverflow)
+	}
+}
+
+// binaryOp pops the right and left operands and pushes fn(lhs, rhs).
+func binaryOp(ctx vm.Context, fn func(lhs, rhs vm.Value) vm.Value) {
+	rhs := popValue(ctx)
+	lhs := popValue(ctx)
+	pushValue(ctx, fn(lhs, rhs))
+}
+
+// frameOp applies fn to the current frame, which must hold at least depth
+// values. fn fails only if the frame is full.
+func frameOp(ctx vm.Context, depth int, fn func(*vm.StackFrame) bool) {
+	frame, ok := ctx.Stack().Get(-1)
+	if !ok || depth < 1 || frame.Max < uint(depth) {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return
+	}
+	if !fn(frame) {
+		ctx.Fault(vm.FaultStackOverflow)
+	}
+}
+
+// OpNoop does nothing.
+func OpNoop(ctx vm.Context) {}
+
+// OpPush pushes a constant value onto the stack. Args that do not fit in
+// the runtime's width are wrapped or saturated, as arithmetic results are.
 func OpPush(ctx vm.Context) {
-	ctx.Stack().PushValue(ctx.Instr().Arg)
+	pushValue(ctx, ctx.Runtime().Arith().Fit(int64(ctx.Instr().Arg)))
 }
 
 // OpPop pops a value from the current frame.
 func OpPop(ctx vm.Context) {
-	ctx.Stack().PopValues(1)
+	if ctx.Stack().PopValues(1) < 1 {
+		ctx.Fault(vm.FaultStackUnderflow)
+	}
 }
 
-// OpCall pushes a new frame onto the stack.
+// OpCall pushes a new frame onto the stack and jumps past the next matching
+// label. The new frame returns to the instruction following the call and
+// receives Runtime.CallArgs values from the caller's frame.
 func OpCall(ctx vm.Context) {
 	iptr, ok := ctx.Script().FindNextLabel(ctx.Instr().Arg)
 	if !ok {
+		ctx.Fault(vm.FaultUndefinedLabel)
 		return
 	}
+	n := ctx.Runtime().CallArgs
+	var have int
+	if caller, ok := ctx.Stack().Get(-1); ok {
+		have = int(caller.Max)
+	}
+	if !ctx.Stack().Call(ctx.Script().Iptr, n) {
+		ctx.Fault(vm.FaultFrameOverflow)
+		return
+	}
+	if n > have {
+		ctx.Fault(vm.FaultStackUnderflow)
+	}
 	ctx.Script().Jump(iptr + 1)
 }
 
 // OpReturn pops a frame off of the stack and resets the instruction pointer.
+// The top Arg values of the popped frame are handed back to the caller.
 func OpReturn(ctx vm.Context) {
-	frame, ok := ctx.PopFrame()
-	if !ok {
+	if ctx.Stack().Max < 2 {
+		ctx.Fault(vm.FaultFrameUnderflow)
 		return // no frames to process
 	}
-	ctx.Script().Jump(frame.Return)
+	n := int(ctx.Instr().Arg)
+	callee, _ := ctx.Stack().Get(-1)
+	caller, _ := ctx.Stack().Get(-2)
+	if n > int(callee.Max) {
+		ctx.Fault(vm.FaultStackUnderflow)
+		n = int(callee.Max)
+	}
+	if n > vm.FrameSize-int(caller.Max) {
+		ctx.Fault(vm.FaultStackOverflow)
+	}
+	iptr, _ := ctx.Stack().Return(n)
+	ctx.Script().Jump(iptr)
+}
+
+// jumpOffset moves the instruction pointer by offset, relative to the next
+// instruction. An offset of 0 skips the next instruction, as 1 does.
+func jumpOffset(ctx vm.Context, offset vm.Value) {
+	// no jump goes further than the length of the code, so clamp wide
+	// offsets to it before they can overflow the instruction pointer
+	n := vm.Value(len(ctx.Script().Code))
+	switch {
+	case offset == 0:
+		offset = 1 // default to skipping the next instruction
+	case offset > n:
+		offset = n
+	case offset < -n:
+		offset = -n
+	}
+	ctx.Script().JumpOffset(int(offset))
 }
 
-// OpJumpIf ...
+// OpJumpIf pops a value and jumps by Arg if it is not zero. It does not jump
+// if the frame is empty.
 func OpJumpIf(ctx vm.Context) {
 	val, ok := ctx.Stack().GetValue(-1)
 	if ok {
 		ctx.Stack().PopValues(1)
+	} else {
+		ctx.Fault(vm.FaultStackUnderflow)
 	}
-	op := ctx.Instr()
 	if !val.Bool() {
 		return
 	}
-	offset := int(op.Arg)
-	if offset == 0 {
-		offset = 1 // default to skipping the next instruction
+	jumpOffset(ctx, ctx.Instr().Arg)
+}
+
+// OpJumpIfZero pops a value and jumps by Arg if it is zero. Like OpJumpIf, it
+// does not jump if the frame is empty.
+func OpJumpIfZero(ctx vm.Context) {
+	val, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return
+	}
+	if val.Bool() {
+		return
+	}
+	jumpOffset(ctx, ctx.Instr().Arg)
+}
+
+// OpJump jumps by Arg.
+func OpJump(ctx vm.Context) {
+	jumpOffset(ctx, ctx.Instr().Arg)
+}
+
+// OpJumpToLabel jumps past the next label matching Arg, as OpCall does, but
+// stays in the current frame.
+func OpJumpToLabel(ctx vm.Context) {
+	iptr, ok := ctx.Script().FindNextLabel(ctx.Instr().Arg)
+	if !ok {
+		ctx.Fault(vm.FaultUndefinedLabel)
+		return
+	}
+	ctx.Script().Jump(iptr + 1)
+}
+
+// OpJumpIndirect pops an offset and jumps by it. Like Arg for the other
+// jumps, an offset of 0 skips the next instruction.
+func OpJumpIndirect(ctx vm.Context) {
+	offset, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return
 	}
-	ctx.Script().JumpOffset(offset)
+	jumpOffset(ctx, offset)
 }
 
 // OpCompare ...
 func OpCompare(ctx vm.Context) {
-	rhs, _ := ctx.PopValue()
-	lhs, _ := ctx.PopValue()
-	ctx.Stack().PushValue(lhs - rhs)
+	binaryOp(ctx, ctx.Runtime().Arith().Sub)
 }
 
 // OpNot ...
 func OpNot(ctx vm.Context) {
-	val, _ := ctx.PopValue()
-	ctx.Stack().PushValue(val.Not())
+	val := popValue(ctx)
+	pushValue(ctx, val.Not())
 }
 
 // OpInc ...
 func OpInc(ctx vm.Context) {
-	val, _ := ctx.PopValue()
+	val := popValue(ctx)
 	step := ctx.Instr().Arg
 	if step == 0 {
 		step = 1
 	}
-	ctx.Stack().PushValue(val + step)
+	pushValue(ctx, ctx.Runtime().Arith().Add(val, step))
 }
 
 // OpDec ...
 func OpDec(ctx vm.Context) {
-	val, _ := ctx.PopValue()
+	val := popValue(ctx)
 	step := ctx.Instr().Arg
 	if step == 0 {
 		step = 1
 	}
-	ctx.Stack().PushValue(val - step)
+	pushValue(ctx, ctx.Runtime().Arith().Sub(val, step))
 }
 
 // OpLoad ...
@@ -91,8 +214,11 @@ func OpLoad(ctx vm.Context) {
 			ctx.Stack().PopValues(1)
 			val, ok = ctx.Registers().Load(int(i))
 		}
+		if !ok {
+			ctx.Fault(vm.FaultRegisterOutOfRange)
+		}
 	}
-	ctx.Stack().PushValue(val)
+	pushValue(ctx, val)
 }
 
 // OpStore ...
@@ -100,6 +226,7 @@ func OpStore(ctx vm.Context) {
 	op := ctx.Instr()
 	val, ok := ctx.Stack().GetValue(-1)
 	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
 		return
 	}
 	ok = ctx.Registers().Store(int(op.Arg), val)
@@ -108,28 +235,317 @@ func OpStore(ctx vm.Context) {
 		i, ok := ctx.Stack().GetValue(-1)
 		if ok {
 			ctx.Stack().PopValues(1)
-			ctx.Registers().Store(int(i), val)
+			ok = ctx.Registers().Store(int(i), val)
+		}
+		if !ok {
+			ctx.Fault(vm.FaultRegisterOutOfRange)
 		}
 	}
 }
 
-// OpLabel ...
-func OpLabel(ctx vm.Context) {
-	ctx.Stack().Pop(1)
+// OpLabel marks a call target and does nothing when executed.
+func OpLabel(ctx vm.Context) {}
+
+// OpAdd pops two values and pushes their sum.
+func OpAdd(ctx vm.Context) {
+	binaryOp(ctx, ctx.Runtime().Arith().Add)
+}
+
+// OpSub pops two values and pushes their difference.
+func OpSub(ctx vm.Context) {
+	binaryOp(ctx, ctx.Runtime().Arith().Sub)
+}
+
+// OpMul pops two values and pushes their product.
+func OpMul(ctx vm.Context) {
+	binaryOp(ctx, ctx.Runtime().Arith().Mul)
+}
+
+// OpDiv pops two values and pushes their quotient.
+// Dividing by zero faults and pushes 0.
+func OpDiv(ctx vm.Context) {
+	binaryOp(ctx, func(lhs, rhs vm.Value) vm.Value {
+		val, ok := ctx.Runtime().Arith().Div(lhs, rhs)
+		if !ok {
+			ctx.Fault(vm.FaultDivideByZero)
+		}
+		return val
+	})
+}
+
+// OpMod pops two values and pushes the remainder of their quotient.
+// Dividing by zero faults and pushes 0.
+func OpMod(ctx vm.Context) {
+	binaryOp(ctx, func(lhs, rhs vm.Value) vm.Value {
+		val, ok := ctx.Runtime().Arith().Mod(lhs, rhs)
+		if !ok {
+			ctx.Fault(vm.FaultDivideByZero)
+		}
+		return val
+	})
+}
+
+// OpNeg pops a value and pushes its negation.
+func OpNeg(ctx vm.Context) {
+	val := popValue(ctx)
+	pushValue(ctx, ctx.Runtime().Arith().Neg(val))
+}
+
+// OpAnd pops two values and pushes their bitwise and.
+func OpAnd(ctx vm.Context) {
+	binaryOp(ctx, func(lhs, rhs vm.Value) vm.Value { return lhs & rhs })
+}
+
+// OpOr pops two values and pushes their bitwise or.
+func OpOr(ctx vm.Context) {
+	binaryOp(ctx, func(lhs, rhs vm.Value) vm.Value { return lhs | rhs })
+}
+
+// OpXor pops two values and pushes their bitwise exclusive or.
+func OpXor(ctx vm.Context) {
+	binaryOp(ctx, func(lhs, rhs vm.Value) vm.Value { return lhs ^ rhs })
+}
+
+// OpShl pops a shift count and a value and pushes the value shifted left.
+func OpShl(ctx vm.Context) {
+	binaryOp(ctx, ctx.Runtime().Arith().Shl)
+}
+
+// OpShr pops a shift count and a value and pushes the value shifted right.
+func OpShr(ctx vm.Context) {
+	binaryOp(ctx, ctx.Runtime().Arith().Shr)
+}
+
+// OpDup duplicates the top value.
+func OpDup(ctx vm.Context) {
+	frameOp(ctx, 1, (*vm.StackFrame).Dup)
+}
+
+// OpSwap exchanges the top two values.
+func OpSwap(ctx vm.Context) {
+	frameOp(ctx, 2, (*vm.StackFrame).Swap)
+}
+
+// OpOver copies the second value to the top.
+func OpOver(ctx vm.Context) {
+	frameOp(ctx, 2, (*vm.StackFrame).Over)
+}
+
+// OpRot rotates the third value to the top.
+func OpRot(ctx vm.Context) {
+	frameOp(ctx, 3, (*vm.StackFrame).Rot)
+}
+
+// OpPick copies the value Arg places below the top to the top.
+func OpPick(ctx vm.Context) {
+	n := int(ctx.Instr().Arg)
+	frameOp(ctx, n+1, func(frame *vm.StackFrame) bool {
+		return frame.Pick(n)
+	})
+}
+
+// OpSyscall invokes the host function registered under the instruction's arg.
+func OpSyscall(ctx vm.Context) {
+	fn, ok := ctx.Runtime().Syscall(ctx.Instr().Arg)
+	if !ok {
+		ctx.Fault(vm.FaultUnknownSyscall)
+		return
+	}
+	fn(ctx)
+}
+
+// selfModifying faults unless the runtime allows scripts to read and change
+// their code.
+func selfModifying(ctx vm.Context) bool {
+	if !ctx.Runtime().SelfModifying {
+		ctx.Fault(vm.FaultIllegalOp)
+		return false
+	}
+	return true
+}
+
+// popCodePos pops an offset and returns the position it names. Offsets are
+// relative to the instruction pointer, which points at the next
+// instruction, as they are for OpJumpIf.
+func popCodePos(ctx vm.Context) (pos int, ok bool) {
+	offset, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return 0, false
+	}
+	return ctx.Script().Iptr + int(offset), true
+}
+
+// popOp pops an instruction's arg and type, faulting if the type is not a
+// registered opcode: ( type arg -- ).
+func popOp(ctx vm.Context) (instr vm.Op, ok bool) {
+	arg, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return vm.Op{}, false
+	}
+	typ, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return vm.Op{}, false
+	}
+	instr = vm.Op{Type: vm.OpCode(typ), Arg: arg}
+	if _, ok := instr.Type.Info(); !ok {
+		ctx.Fault(vm.FaultIllegalOp)
+		return vm.Op{}, false
+	}
+	return instr, true
+}
+
+// OpCodeLen pushes the length of the script, or the largest value of the
+// runtime's width if it is longer: ( -- n ).
+func OpCodeLen(ctx vm.Context) {
+	if !selfModifying(ctx) {
+		return
+	}
+	n := vm.Value(len(ctx.Script().Code))
+	if max := ctx.Runtime().Width.Max(); n > max {
+		n = max
+	}
+	pushValue(ctx, n)
+}
+
+// OpReadOp pops an offset and pushes the type and arg of the instruction at
+// that offset: ( offset -- type arg ).
+func OpReadOp(ctx vm.Context) {
+	if !selfModifying(ctx) {
+		return
+	}
+	pos, ok := popCodePos(ctx)
+	if !ok {
+		return
+	}
+	if pos < 0 || pos >= len(ctx.Script().Code) {
+		ctx.Fault(vm.FaultCodeOutOfRange)
+		return
+	}
+	instr := ctx.Script().Code[pos]
+	pushValue(ctx, vm.Value(instr.Type))
+	pushValue(ctx, ctx.Runtime().Arith().Fit(int64(instr.Arg)))
+}
+
+// OpWriteOp pops an offset and an instruction and replaces the instruction
+// at that offset with it: ( type arg offset -- ).
+func OpWriteOp(ctx vm.Context) {
+	if !selfModifying(ctx) {
+		return
+	}
+	pos, ok := popCodePos(ctx)
+	if !ok {
+		return
+	}
+	instr, ok := popOp(ctx)
+	if !ok {
+		return
+	}
+	if !ctx.Script().SetOp(pos, instr) {
+		ctx.Fault(vm.FaultCodeOutOfRange)
+	}
 }
 
-// Map ...
-var Map = vm.Impl{
-	vm.OpPush:    OpPush,
-	vm.OpPop:     OpPop,
-	vm.OpCall:    OpCall,
-	vm.OpReturn:  OpReturn,
-	vm.OpJumpIf:  OpJumpIf,
-	vm.OpCompare: OpCompare,
-	vm.OpNot:     OpNot,
-	vm.OpInc:     OpInc,
-	vm.OpDec:     OpDec,
-	vm.OpLoad:    OpLoad,
-	vm.OpStore:   OpStore,
-	vm.OpLabel:   OpLabel,
+// OpInsertOp pops an offset and an instruction and inserts the instruction
+// before the one at that offset: ( type arg offset -- ). The offset may
+// name the end of the script. The instruction pointer and return addresses
+// keep pointing at the same instructions.
+func OpInsertOp(ctx vm.Context) {
+	if !selfModifying(ctx) {
+		return
+	}
+	pos, ok := popCodePos(ctx)
+	if !ok {
+		return
+	}
+	instr, ok := popOp(ctx)
+	if !ok {
+		return
+	}
+	script := ctx.Script()
+	if max := ctx.Runtime().MaxCodeLen; max > 0 && len(script.Code) >= max {
+		ctx.Fault(vm.FaultCodeOutOfRange)
+		return
+	}
+	if !script.InsertOp(pos, instr) {
+		ctx.Fault(vm.FaultCodeOutOfRange)
+		return
+	}
+	ctx.Stack().ShiftReturns(pos, 1)
+}
+
+// OpDeleteOp pops an offset and removes the instruction at that offset:
+// ( offset -- ). The instruction pointer and return addresses keep pointing
+// at the same instructions, or at the one that followed the deleted one.
+func OpDeleteOp(ctx vm.Context) {
+	if !selfModifying(ctx) {
+		return
+	}
+	pos, ok := popCodePos(ctx)
+	if !ok {
+		return
+	}
+	if !ctx.Script().DeleteOp(pos) {
+		ctx.Fault(vm.FaultCodeOutOfRange)
+		return
+	}
+	ctx.Stack().ShiftReturns(pos+1, -1)
+}
+
+// Map holds the handlers of every registered opcode, including the ones
+// added with vm.RegisterOp. It is shared with the registry rather than
+// copied, so changing it changes the handlers of every runtime that uses it;
+// copy it to give a runtime handlers of its own.
+var Map = vm.RegisteredImpl()
+
+func init() {
+	for op, fn := range builtins {
+		vm.SetOpImpl(op, fn)
+	}
+}
+
+// builtins holds the handlers of the opcodes vm always registers.
+var builtins = map[vm.OpCode]vm.OpImpl{
+	vm.OpNoop:     OpNoop,
+	vm.OpPush:     OpPush,
+	vm.OpPop:      OpPop,
+	vm.OpCall:     OpCall,
+	vm.OpReturn:   OpReturn,
+	vm.OpJumpIf:   OpJumpIf,
+	vm.OpCompare:  OpCompare,
+	vm.OpNot:      OpNot,
+	vm.OpInc:      OpInc,
+	vm.OpDec:      OpDec,
+	vm.OpLoad:     OpLoad,
+	vm.OpStore:    OpStore,
+	vm.OpLabel:    OpLabel,
+	vm.OpSyscall:  OpSyscall,
+	vm.OpAdd:      OpAdd,
+	vm.OpSub:      OpSub,
+	vm.OpMul:      OpMul,
+	vm.OpDiv:      OpDiv,
+	vm.OpMod:      OpMod,
+	vm.OpNeg:      OpNeg,
+	vm.OpAnd:      OpAnd,
+	vm.OpOr:       OpOr,
+	vm.OpXor:      OpXor,
+	vm.OpShl:      OpShl,
+	vm.OpShr:      OpShr,
+	vm.OpDup:      OpDup,
+	vm.OpSwap:     OpSwap,
+	vm.OpOver:     OpOver,
+	vm.OpRot:      OpRot,
+	vm.OpPick:     OpPick,
+	vm.OpCodeLen:  OpCodeLen,
+	vm.OpReadOp:   OpReadOp,
+	vm.OpWriteOp:  OpWriteOp,
+	vm.OpInsertOp: OpInsertOp,
+	vm.OpDeleteOp: OpDeleteOp,
+
+	vm.OpJump:         OpJump,
+	vm.OpJumpIfZero:   OpJumpIfZero,
+	vm.OpJumpToLabel:  OpJumpToLabel,
+	vm.OpJumpIndirect: OpJumpIndirect,
 }
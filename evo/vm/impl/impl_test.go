@@ -0,0 +1,734 @@
+package impl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+func newTestRuntime() *vm.Runtime {
+	return &vm.Runtime{
+		Impl:  Map,
+		Hooks: vm.RuntimeWithMaxIterations(100),
+	}
+}
+
+func TestOpSyscall(t *testing.T) {
+	double := func(ctx vm.Context) {
+		val, _ := ctx.PopValue()
+		ctx.Stack().PushValue(2 * val)
+	}
+	sense := func(ctx vm.Context) {
+		ctx.Registers().Store(0, 42)
+	}
+	tests := []struct {
+		name          string
+		code          []vm.Op
+		wantStack     []vm.Value
+		wantRegisters vm.Register
+	}{
+		{
+			name: "read and write stack",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 21},
+				vm.Op{Type: vm.OpSyscall, Arg: 1},
+			},
+			wantStack:     []vm.Value{42},
+			wantRegisters: vm.Register{0, 0},
+		},
+		{
+			name: "write register",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpSyscall, Arg: 2},
+			},
+			wantRegisters: vm.Register{42, 0},
+		},
+		{
+			name: "unknown syscall",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 7},
+				vm.Op{Type: vm.OpSyscall, Arg: 3},
+			},
+			wantStack:     []vm.Value{7},
+			wantRegisters: vm.Register{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime().
+				AddSyscall(1, double).
+				AddSyscall(2, sense)
+			state := vm.State{
+				Script:    vm.Script{Code: tt.code},
+				Registers: make(vm.Register, 2),
+			}
+			runtime.Run(&state)
+			frame, _ := state.Stack.Get(-1)
+			var got []vm.Value
+			if frame != nil {
+				got = frame.Values()
+			}
+			assert.Equal(t, tt.wantStack, got)
+			assert.Equal(t, tt.wantRegisters, state.Registers)
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name           string
+		code           []vm.Op
+		haltOnFault    bool
+		wantFaults     int
+		wantFault      *vm.Fault
+		wantIterations int
+	}{
+		{
+			name: "no faults",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 1},
+				vm.Op{Type: vm.OpPop},
+			},
+			wantIterations: 3,
+		},
+		{
+			name: "pop empty frame",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpNoop},
+				vm.Op{Type: vm.OpPop},
+				vm.Op{Type: vm.OpPop},
+			},
+			wantFaults:     2,
+			wantFault:      &vm.Fault{Kind: vm.FaultStackUnderflow, Iptr: 1, Op: vm.Op{Type: vm.OpPop}},
+			wantIterations: 4,
+		},
+		{
+			name: "push full frame",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush},
+				vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush}, vm.Op{Type: vm.OpPush},
+				vm.Op{Type: vm.OpPush, Arg: 9},
+			},
+			wantFaults:     1,
+			wantFault:      &vm.Fault{Kind: vm.FaultStackOverflow, Iptr: 8, Op: vm.Op{Type: vm.OpPush, Arg: 9}},
+			wantIterations: 10,
+		},
+		{
+			name: "store out of range",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 5},
+				vm.Op{Type: vm.OpStore, Arg: 5},
+			},
+			wantFaults:     1,
+			wantFault:      &vm.Fault{Kind: vm.FaultRegisterOutOfRange, Iptr: 1, Op: vm.Op{Type: vm.OpStore, Arg: 5}},
+			wantIterations: 3,
+		},
+		{
+			name: "illegal op",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpMax},
+			},
+			wantFaults:     1,
+			wantFault:      &vm.Fault{Kind: vm.FaultIllegalOp, Op: vm.Op{Type: vm.OpMax}},
+			wantIterations: 2,
+		},
+		{
+			name: "halt on fault",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPop},
+				vm.Op{Type: vm.OpPop},
+				vm.Op{Type: vm.OpPop},
+			},
+			haltOnFault:    true,
+			wantFaults:     1,
+			wantFault:      &vm.Fault{Kind: vm.FaultStackUnderflow, Op: vm.Op{Type: vm.OpPop}},
+			wantIterations: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime()
+			runtime.HaltOnFault = tt.haltOnFault
+			state := vm.State{
+				Script:    vm.Script{Code: tt.code},
+				Registers: make(vm.Register, 2),
+			}
+			result := runtime.Run(&state)
+			assert.Equal(t, tt.wantFaults, result.Faults)
+			assert.Equal(t, tt.wantFault, result.Fault)
+			assert.Equal(t, tt.wantIterations, result.Iterations)
+		})
+	}
+}
+
+func TestOpCall(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          []vm.Op
+		callArgs      int
+		wantRegisters vm.Register
+		wantFaults    int
+		wantFault     *vm.Fault
+	}{
+		{
+			name: "call and return",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 5},
+				vm.Op{Type: vm.OpCall, Arg: 1},
+				vm.Op{Type: vm.OpStore, Arg: 0},
+				vm.Op{Type: vm.OpPush, Arg: 1},
+				vm.Op{Type: vm.OpJumpIf, Arg: 100},
+				vm.Op{Type: vm.OpLabel, Arg: 1},
+				vm.Op{Type: vm.OpInc},
+				vm.Op{Type: vm.OpReturn, Arg: 1},
+			},
+			callArgs:      1,
+			wantRegisters: vm.Register{6, 0},
+		},
+		{
+			name: "nested",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpPush, Arg: 5},
+				vm.Op{Type: vm.OpCall, Arg: 1},
+				vm.Op{Type: vm.OpStore, Arg: 0},
+				vm.Op{Type: vm.OpPush, Arg: 1},
+				vm.Op{Type: vm.OpJumpIf, Arg: 100},
+				vm.Op{Type: vm.OpLabel, Arg: 1},
+				vm.Op{Type: vm.OpInc},
+				vm.Op{Type: vm.OpCall, Arg: 2},
+				vm.Op{Type: vm.OpStore, Arg: 1},
+				vm.Op{Type: vm.OpReturn, Arg: 1},
+				vm.Op{Type: vm.OpLabel, Arg: 2},
+				vm.Op{Type: vm.OpInc, Arg: 2},
+				vm.Op{Type: vm.OpReturn, Arg: 1},
+			},
+			callArgs:      1,
+			wantRegisters: vm.Register{8, 8},
+		},
+		{
+			name: "recursive",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpCall, Arg: 1},
+				vm.Op{Type: vm.OpPush, Arg: 1},
+				vm.Op{Type: vm.OpJumpIf, Arg: 100},
+				vm.Op{Type: vm.OpLabel, Arg: 1},
+				vm.Op{Type: vm.OpCall, Arg: 1},
+				vm.Op{Type: vm.OpReturn},
+			},
+			wantRegisters: vm.Register{0, 0},
+			wantFaults:    1,
+			wantFault:     &vm.Fault{Kind: vm.FaultFrameOverflow, Iptr: 4, Op: vm.Op{Type: vm.OpCall, Arg: 1}},
+		},
+		{
+			name: "undefined label",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpCall, Arg: 1},
+			},
+			wantRegisters: vm.Register{0, 0},
+			wantFaults:    1,
+			wantFault:     &vm.Fault{Kind: vm.FaultUndefinedLabel, Op: vm.Op{Type: vm.OpCall, Arg: 1}},
+		},
+		{
+			name: "return without caller",
+			code: []vm.Op{
+				vm.Op{Type: vm.OpReturn},
+			},
+			wantRegisters: vm.Register{0, 0},
+			wantFaults:    1,
+			wantFault:     &vm.Fault{Kind: vm.FaultFrameUnderflow, Op: vm.Op{Type: vm.OpReturn}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime()
+			runtime.CallArgs = tt.callArgs
+			state := vm.State{
+				Script:    vm.Script{Code: tt.code},
+				Registers: make(vm.Register, 2),
+			}
+			result := runtime.Run(&state)
+			assert.False(t, result.Interrupted)
+			assert.Equal(t, tt.wantRegisters, state.Registers)
+			assert.Equal(t, tt.wantFaults, result.Faults)
+			assert.Equal(t, tt.wantFault, result.Fault)
+			assert.LessOrEqual(t, state.Stack.Max, uint(1))
+		})
+	}
+}
+
+func TestJumps(t *testing.T) {
+	noop := vm.Op{Type: vm.OpNoop}
+	tests := []struct {
+		name      string
+		code      []vm.Op
+		steps     int
+		wantIptr  int
+		wantStack []vm.Value
+		wantFault *vm.Fault
+	}{
+		{
+			name:     "jump forward",
+			code:     []vm.Op{{Type: vm.OpJump, Arg: 2}, noop, noop, noop},
+			steps:    1,
+			wantIptr: 3,
+		},
+		{
+			name:     "jump backward",
+			code:     []vm.Op{noop, noop, {Type: vm.OpJump, Arg: -3}},
+			steps:    3,
+			wantIptr: 0,
+		},
+		{
+			name:     "jump 0 skips the next instruction",
+			code:     []vm.Op{{Type: vm.OpJump}, noop, noop},
+			steps:    1,
+			wantIptr: 2,
+		},
+		{
+			name:     "jump past the end is clamped",
+			code:     []vm.Op{{Type: vm.OpJump, Arg: 100}, noop},
+			steps:    1,
+			wantIptr: 2,
+		},
+		{
+			name:     "widest jump past the end is clamped",
+			code:     []vm.Op{{Type: vm.OpJump, Arg: vm.Width64.Max()}, noop},
+			steps:    1,
+			wantIptr: 2,
+		},
+		{
+			name:     "jump before the start is clamped",
+			code:     []vm.Op{noop, {Type: vm.OpJump, Arg: -100}},
+			steps:    2,
+			wantIptr: 0,
+		},
+		{
+			name:     "widest jump before the start is clamped",
+			code:     []vm.Op{noop, {Type: vm.OpJump, Arg: vm.Width64.Min()}},
+			steps:    2,
+			wantIptr: 0,
+		},
+		{
+			name:     "jumpifzero jumps on zero",
+			code:     []vm.Op{{Type: vm.OpPush, Arg: 0}, {Type: vm.OpJumpIfZero, Arg: 1}, noop, noop},
+			steps:    2,
+			wantIptr: 3,
+		},
+		{
+			name:     "jumpifzero falls through otherwise",
+			code:     []vm.Op{{Type: vm.OpPush, Arg: 1}, {Type: vm.OpJumpIfZero, Arg: 1}, noop, noop},
+			steps:    2,
+			wantIptr: 2,
+		},
+		{
+			name:      "jumpifzero on an empty frame faults without jumping",
+			code:      []vm.Op{{Type: vm.OpJumpIfZero, Arg: 1}, noop, noop},
+			steps:     1,
+			wantIptr:  1,
+			wantFault: &vm.Fault{Kind: vm.FaultStackUnderflow, Op: vm.Op{Type: vm.OpJumpIfZero, Arg: 1}},
+		},
+		{
+			name:     "jumptolabel",
+			code:     []vm.Op{{Type: vm.OpJumpToLabel, Arg: 1}, noop, {Type: vm.OpLabel, Arg: 1}, noop},
+			steps:    1,
+			wantIptr: 3,
+		},
+		{
+			name:     "jumptolabel wraps around",
+			code:     []vm.Op{noop, {Type: vm.OpLabel, Arg: 1}, noop, {Type: vm.OpJumpToLabel, Arg: 1}, noop},
+			steps:    4,
+			wantIptr: 2,
+		},
+		{
+			name:      "jumptolabel undefined",
+			code:      []vm.Op{{Type: vm.OpJumpToLabel, Arg: 2}, {Type: vm.OpLabel, Arg: 1}, noop},
+			steps:     1,
+			wantIptr:  1,
+			wantFault: &vm.Fault{Kind: vm.FaultUndefinedLabel, Op: vm.Op{Type: vm.OpJumpToLabel, Arg: 2}},
+		},
+		{
+			name:     "jumpindirect forward",
+			code:     []vm.Op{{Type: vm.OpPush, Arg: 2}, {Type: vm.OpJumpIndirect}, noop, noop, noop},
+			steps:    2,
+			wantIptr: 4,
+		},
+		{
+			name:     "jumpindirect backward",
+			code:     []vm.Op{noop, {Type: vm.OpPush, Arg: -3}, {Type: vm.OpJumpIndirect}},
+			steps:    3,
+			wantIptr: 0,
+		},
+		{
+			name:     "jumpindirect 0 skips the next instruction",
+			code:     []vm.Op{{Type: vm.OpPush, Arg: 0}, {Type: vm.OpJumpIndirect}, noop, noop},
+			steps:    2,
+			wantIptr: 3,
+		},
+		{
+			name:      "jumpindirect pops only its offset",
+			code:      []vm.Op{{Type: vm.OpPush, Arg: 7}, {Type: vm.OpPush, Arg: 100}, {Type: vm.OpJumpIndirect}, noop},
+			steps:     3,
+			wantIptr:  4,
+			wantStack: []vm.Value{7},
+		},
+		{
+			name:      "jumpindirect on an empty frame faults without jumping",
+			code:      []vm.Op{{Type: vm.OpJumpIndirect}, noop, noop},
+			steps:     1,
+			wantIptr:  1,
+			wantFault: &vm.Fault{Kind: vm.FaultStackUnderflow, Op: vm.Op{Type: vm.OpJumpIndirect}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime()
+			state := vm.State{Script: vm.Script{Code: tt.code}}
+			var result vm.RunResult
+			for i := 0; i < tt.steps; i++ {
+				runtime.Step(&state, &result)
+			}
+			assert.Equal(t, tt.wantIptr, state.Script.Iptr)
+			assert.Equal(t, tt.wantFault, result.Fault)
+			var stack []vm.Value
+			if frame, ok := state.Stack.Get(-1); ok {
+				stack = append(stack, frame.Values()...)
+			}
+			assert.Equal(t, tt.wantStack, stack)
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         vm.OpCode
+		operands   []vm.Value
+		overflow   vm.Overflow
+		width      vm.Width
+		wantStack  []vm.Value
+		wantFaults int
+	}{
+		{name: "add", op: vm.OpAdd, operands: []vm.Value{3, 4}, wantStack: []vm.Value{7}},
+		{name: "add wrap", op: vm.OpAdd, operands: []vm.Value{vm.MaxValue, 1}, wantStack: []vm.Value{vm.MinValue}},
+		{name: "add saturate", op: vm.OpAdd, operands: []vm.Value{vm.MaxValue, 1}, overflow: vm.OverflowSaturate, wantStack: []vm.Value{vm.MaxValue}},
+		{name: "sub", op: vm.OpSub, operands: []vm.Value{3, 4}, wantStack: []vm.Value{-1}},
+		{name: "mul", op: vm.OpMul, operands: []vm.Value{3, 4}, wantStack: []vm.Value{12}},
+		{name: "div", op: vm.OpDiv, operands: []vm.Value{9, 4}, wantStack: []vm.Value{2}},
+		{name: "div by zero", op: vm.OpDiv, operands: []vm.Value{9, 0}, wantStack: []vm.Value{0}, wantFaults: 1},
+		{name: "mod", op: vm.OpMod, operands: []vm.Value{9, 4}, wantStack: []vm.Value{1}},
+		{name: "mod by zero", op: vm.OpMod, operands: []vm.Value{9, 0}, wantStack: []vm.Value{0}, wantFaults: 1},
+		{name: "neg", op: vm.OpNeg, operands: []vm.Value{9}, wantStack: []vm.Value{-9}},
+		{name: "and", op: vm.OpAnd, operands: []vm.Value{6, 3}, wantStack: []vm.Value{2}},
+		{name: "or", op: vm.OpOr, operands: []vm.Value{6, 3}, wantStack: []vm.Value{7}},
+		{name: "xor", op: vm.OpXor, operands: []vm.Value{6, 3}, wantStack: []vm.Value{5}},
+		{name: "shl", op: vm.OpShl, operands: []vm.Value{6, 3}, wantStack: []vm.Value{48}},
+		{name: "shr", op: vm.OpShr, operands: []vm.Value{-6, 1}, wantStack: []vm.Value{-3}},
+		{name: "missing operand", op: vm.OpAdd, operands: []vm.Value{6}, wantStack: []vm.Value{6}, wantFaults: 1},
+		{name: "add wide", op: vm.OpAdd, operands: []vm.Value{vm.MaxValue, 1}, width: vm.Width16, wantStack: []vm.Value{128}},
+		{name: "mul wide wrap", op: vm.OpMul, operands: []vm.Value{300, 300}, width: vm.Width16, wantStack: []vm.Value{24464}},
+		{name: "mul wide saturate", op: vm.OpMul, operands: []vm.Value{300, 300}, overflow: vm.OverflowSaturate, width: vm.Width16, wantStack: []vm.Value{32767}},
+		{name: "push wraps to the width", op: vm.OpNoop, operands: []vm.Value{300}, wantStack: []vm.Value{44}},
+		{name: "push saturates to the width", op: vm.OpNoop, operands: []vm.Value{300}, overflow: vm.OverflowSaturate, wantStack: []vm.Value{vm.MaxValue}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code []vm.Op
+			for _, val := range tt.operands {
+				code = append(code, vm.Op{Type: vm.OpPush, Arg: val})
+			}
+			code = append(code, vm.Op{Type: tt.op})
+			runtime := newTestRuntime()
+			runtime.Overflow = tt.overflow
+			runtime.Width = tt.width
+			state := vm.State{Script: vm.Script{Code: code}}
+			result := runtime.Run(&state)
+			frame, _ := state.Stack.Get(-1)
+			assert.Equal(t, tt.wantStack, frame.Values())
+			assert.Equal(t, tt.wantFaults, result.Faults)
+		})
+	}
+}
+
+func TestStackManipulation(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         vm.Op
+		operands   []vm.Value
+		wantStack  []vm.Value
+		wantFault  vm.FaultKind
+		wantFaults int
+	}{
+		{name: "dup", op: vm.Op{Type: vm.OpDup}, operands: []vm.Value{1, 2}, wantStack: []vm.Value{1, 2, 2}},
+		{name: "swap", op: vm.Op{Type: vm.OpSwap}, operands: []vm.Value{1, 2}, wantStack: []vm.Value{2, 1}},
+		{name: "over", op: vm.Op{Type: vm.OpOver}, operands: []vm.Value{1, 2}, wantStack: []vm.Value{1, 2, 1}},
+		{name: "rot", op: vm.Op{Type: vm.OpRot}, operands: []vm.Value{1, 2, 3}, wantStack: []vm.Value{2, 3, 1}},
+		{name: "pick", op: vm.Op{Type: vm.OpPick, Arg: 2}, operands: []vm.Value{1, 2, 3}, wantStack: []vm.Value{1, 2, 3, 1}},
+		{name: "rot underflow", op: vm.Op{Type: vm.OpRot}, operands: []vm.Value{1, 2}, wantStack: []vm.Value{1, 2}, wantFault: vm.FaultStackUnderflow, wantFaults: 1},
+		{name: "pick negative", op: vm.Op{Type: vm.OpPick, Arg: -1}, operands: []vm.Value{1}, wantStack: []vm.Value{1}, wantFault: vm.FaultStackUnderflow, wantFaults: 1},
+		{name: "dup overflow", op: vm.Op{Type: vm.OpDup}, operands: []vm.Value{1, 2, 3, 4, 5, 6, 7, 8}, wantStack: []vm.Value{1, 2, 3, 4, 5, 6, 7, 8}, wantFault: vm.FaultStackOverflow, wantFaults: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code []vm.Op
+			for _, val := range tt.operands {
+				code = append(code, vm.Op{Type: vm.OpPush, Arg: val})
+			}
+			code = append(code, tt.op)
+			state := vm.State{Script: vm.Script{Code: code}}
+			result := newTestRuntime().Run(&state)
+			frame, _ := state.Stack.Get(-1)
+			assert.Equal(t, tt.wantStack, frame.Values())
+			assert.Equal(t, tt.wantFaults, result.Faults)
+			if result.Fault != nil {
+				assert.Equal(t, tt.wantFault, result.Fault.Kind)
+			}
+		})
+	}
+}
+
+func TestGas(t *testing.T) {
+	code := []vm.Op{
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpPush, Arg: 2},
+		vm.Op{Type: vm.OpMul},
+		vm.Op{Type: vm.OpStore, Arg: 0},
+	}
+	costs := vm.CostTable{vm.OpMul: 5}
+	tests := []struct {
+		name         string
+		gas          int
+		wantGas      int
+		wantGasUsed  int
+		wantOutOfGas bool
+		wantIptr     int
+	}{
+		{name: "enough gas", gas: 10, wantGas: 2, wantGasUsed: 8, wantIptr: 4},
+		{name: "exact gas", gas: 8, wantGas: 0, wantGasUsed: 8, wantIptr: 4},
+		{name: "out of gas before expensive op", gas: 6, wantGas: 4, wantGasUsed: 2, wantOutOfGas: true, wantIptr: 2},
+		{name: "no gas", wantOutOfGas: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime()
+			runtime.Costs = costs
+			for _, engine := range map[string]func(*vm.State) vm.RunResult{
+				"runtime": runtime.Run,
+				"program": runtime.Compile(code).Run,
+			} {
+				state := vm.State{
+					Script:    vm.Script{Code: code},
+					Registers: make(vm.Register, 1),
+					Gas:       tt.gas,
+				}
+				result := engine(&state)
+				assert.Equal(t, tt.wantGas, state.Gas)
+				assert.Equal(t, tt.wantGasUsed, result.GasUsed)
+				assert.Equal(t, tt.wantOutOfGas, result.OutOfGas)
+				assert.Equal(t, tt.wantIptr, state.Script.Iptr)
+			}
+		})
+	}
+}
+
+func TestEffects(t *testing.T) {
+	for _, op := range vm.RegisteredOps() {
+		info, _ := op.Info()
+		if info.Effect == nil || info.Effect.Flow != vm.FlowNext || info.Effect.Code != vm.AccessNone {
+			continue // self-modifying opcodes are tested in TestSelfModifying
+		}
+		for _, arg := range []vm.Value{0, 1, 5} {
+			instr := vm.Op{Type: op, Arg: arg}
+			t.Run(fmt.Sprintf("%v %d", op, arg), func(t *testing.T) {
+				e, ok := vm.EffectOf(instr, 2)
+				assert.True(t, ok)
+				var state vm.State
+				for i := 0; i < e.In; i++ {
+					state.Stack.PushValue(1) // a valid register and a nonzero divisor
+				}
+				state.Registers = make(vm.Register, 2)
+				fault := newTestRuntime().Exec(&state, instr)
+				assert.Nil(t, fault)
+				var depth int
+				if frame, ok := state.Stack.Get(-1); ok {
+					depth = len(frame.Values())
+				}
+				assert.Equal(t, e.Out, depth)
+			})
+		}
+	}
+}
+
+func TestEffects_PickOutOfRange(t *testing.T) {
+	for _, arg := range []vm.Value{-1, vm.FrameSize, vm.Width64.Max(), vm.Width64.Min()} {
+		t.Run(fmt.Sprintf("%d", arg), func(t *testing.T) {
+			e, ok := vm.EffectOf(vm.Op{Type: vm.OpPick, Arg: arg}, 2)
+			assert.True(t, ok)
+			assert.Equal(t, vm.FrameSize+1, e.In, "no frame holds enough values")
+		})
+	}
+}
+
+func TestSelfModifying(t *testing.T) {
+	push := func(val vm.Value) vm.Op { return vm.Op{Type: vm.OpPush, Arg: val} }
+	tests := []struct {
+		name          string
+		code          []vm.Op
+		disabled      bool
+		maxCodeLen    int
+		wantRegisters vm.Register
+		wantCodeLen   int
+		wantFault     vm.FaultKind
+	}{
+		{
+			name:          "disabled",
+			code:          []vm.Op{{Type: vm.OpCodeLen}, {Type: vm.OpStore}},
+			disabled:      true,
+			wantRegisters: vm.Register{0, 0},
+			wantCodeLen:   2,
+			wantFault:     vm.FaultIllegalOp,
+		},
+		{
+			name:          "code len",
+			code:          []vm.Op{{Type: vm.OpCodeLen}, {Type: vm.OpStore}},
+			wantRegisters: vm.Register{2, 0},
+			wantCodeLen:   2,
+		},
+		{
+			name: "read",
+			code: []vm.Op{
+				push(2),
+				{Type: vm.OpReadOp},
+				{Type: vm.OpStore, Arg: 1},
+				{Type: vm.OpPop},
+				{Type: vm.OpStore, Arg: 0},
+			},
+			wantRegisters: vm.Register{vm.Value(vm.OpStore), 0},
+			wantCodeLen:   5,
+		},
+		{
+			name: "write the next instruction",
+			code: []vm.Op{
+				push(vm.Value(vm.OpPush)),
+				push(7),
+				push(0),
+				{Type: vm.OpWriteOp},
+				{Type: vm.OpNoop},
+				{Type: vm.OpStore},
+			},
+			wantRegisters: vm.Register{7, 0},
+			wantCodeLen:   6,
+		},
+		{
+			name: "insert behind the instruction pointer",
+			code: []vm.Op{
+				push(vm.Value(vm.OpInc)),
+				push(1),
+				push(-1),
+				{Type: vm.OpInsertOp},
+				push(3),
+				{Type: vm.OpStore},
+			},
+			wantRegisters: vm.Register{3, 0},
+			wantCodeLen:   7,
+		},
+		{
+			name: "insert ahead of the instruction pointer",
+			code: []vm.Op{
+				push(vm.Value(vm.OpInc)),
+				push(2),
+				push(1),
+				{Type: vm.OpInsertOp},
+				push(3),
+				{Type: vm.OpStore},
+			},
+			wantRegisters: vm.Register{5, 0},
+			wantCodeLen:   7,
+		},
+		{
+			name: "delete the next instruction",
+			code: []vm.Op{
+				push(3),
+				push(0),
+				{Type: vm.OpDeleteOp},
+				{Type: vm.OpInc},
+				{Type: vm.OpStore},
+			},
+			wantRegisters: vm.Register{3, 0},
+			wantCodeLen:   4,
+		},
+		{
+			name: "delete itself",
+			code: []vm.Op{
+				push(-1),
+				{Type: vm.OpDeleteOp},
+				push(4),
+				{Type: vm.OpStore},
+			},
+			wantRegisters: vm.Register{4, 0},
+			wantCodeLen:   3,
+		},
+		{
+			name: "delete before a return address",
+			code: []vm.Op{
+				{Type: vm.OpNoop},
+				{Type: vm.OpCall, Arg: 1},
+				{Type: vm.OpStore},
+				push(1),
+				{Type: vm.OpJumpIf, Arg: 100},
+				{Type: vm.OpLabel, Arg: 1},
+				push(-8),
+				{Type: vm.OpDeleteOp},
+				push(9),
+				{Type: vm.OpReturn, Arg: 1},
+			},
+			wantRegisters: vm.Register{9, 0},
+			wantCodeLen:   9,
+		},
+		{
+			name:          "out of range",
+			code:          []vm.Op{push(100), {Type: vm.OpReadOp}},
+			wantRegisters: vm.Register{0, 0},
+			wantCodeLen:   2,
+			wantFault:     vm.FaultCodeOutOfRange,
+		},
+		{
+			name:          "code too long",
+			code:          []vm.Op{push(0), push(0), push(0), {Type: vm.OpInsertOp}},
+			maxCodeLen:    4,
+			wantRegisters: vm.Register{0, 0},
+			wantCodeLen:   4,
+			wantFault:     vm.FaultCodeOutOfRange,
+		},
+		{
+			name:          "illegal op",
+			code:          []vm.Op{push(-1), push(0), push(0), {Type: vm.OpWriteOp}},
+			wantRegisters: vm.Register{0, 0},
+			wantCodeLen:   4,
+			wantFault:     vm.FaultIllegalOp,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime := newTestRuntime()
+			runtime.SelfModifying = !tt.disabled
+			runtime.MaxCodeLen = tt.maxCodeLen
+			original := append([]vm.Op(nil), tt.code...)
+			state := vm.State{
+				Script:    vm.Script{Code: tt.code},
+				Registers: make(vm.Register, 2),
+			}
+			result := runtime.Run(&state)
+			assert.Equal(t, tt.wantRegisters, state.Registers)
+			assert.Len(t, state.Script.Code, tt.wantCodeLen)
+			assert.Equal(t, original, tt.code, "the original code is never modified")
+			if tt.wantFault == vm.FaultNone {
+				assert.Nil(t, result.Fault)
+			} else if assert.NotNil(t, result.Fault) {
+				assert.Equal(t, tt.wantFault, result.Fault.Kind)
+			}
+		})
+	}
+}
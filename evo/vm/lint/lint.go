@@ -0,0 +1,123 @@
+// Package lint reports likely mistakes in scripts.
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/analysis"
+	"github.com/jncornett/beans-engine/evo/vm/cfg"
+)
+
+// Checks reported by Lint.
+const (
+	CheckUndefinedLabel = "undefined-label"
+	CheckJumpRange      = "jump-out-of-range"
+	CheckRegisterRange  = "register-out-of-range"
+	CheckArgRange       = "arg-out-of-range"
+	CheckUnreachable    = "unreachable"
+	CheckUnderflow      = "stack-underflow"
+	CheckOverflow       = "stack-overflow"
+	CheckFrameOverflow  = "frame-overflow"
+)
+
+// Finding is a problem found at an instruction.
+type Finding struct {
+	// Line is the source line of the instruction, numbered from 1.
+	Line    int    `json:"line"`
+	Iptr    int    `json:"iptr"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d: %s (%s)", f.Line, f.Message, f.Check)
+}
+
+// Options ...
+type Options struct {
+	// Registers is the number of registers the script runs with. Register
+	// indexes are not checked if it is 0.
+	Registers int
+	// CallArgs is the number of values a call moves into the new frame.
+	CallArgs int
+	// Width is the width of the runtime's values.
+	Width vm.Width
+	// Lines maps instruction positions to source lines. Positions are
+	// numbered from 1 if it is nil.
+	Lines []int
+}
+
+// Lint checks code and returns its findings ordered by position.
+func Lint(code []vm.Op, opts Options) []Finding {
+	l := linter{code: code, opts: opts, labels: vm.NewLabelIndex(code)}
+	l.checkTargets()
+	l.checkStack()
+	sortFindings(l.findings)
+	return l.findings
+}
+
+type linter struct {
+	code     []vm.Op
+	opts     Options
+	labels   *vm.LabelIndex
+	findings []Finding
+}
+
+func (l *linter) report(iptr int, check, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{
+		Line:    l.line(iptr),
+		Iptr:    iptr,
+		Check:   check,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) line(iptr int) int {
+	if iptr < len(l.opts.Lines) {
+		return l.opts.Lines[iptr]
+	}
+	return iptr + 1
+}
+
+// checkTargets checks the operands of calls, jumps, pushes and register
+// accesses.
+func (l *linter) checkTargets() {
+	for i, instr := range l.code {
+		switch instr.Type {
+		case vm.OpCall, vm.OpJumpToLabel:
+			if _, ok := cfg.CallTarget(l.labels, i, instr.Arg); ok {
+				continue
+			}
+			name := strings.ToLower(instr.Type.String())
+			if _, exists := l.labels.Find(instr.Arg, 0); exists {
+				l.report(i, CheckUndefinedLabel, "%s %d at the end of the script never finds its label", name, instr.Arg)
+			} else {
+				l.report(i, CheckUndefinedLabel, "%s %d has no matching label", name, instr.Arg)
+			}
+		case vm.OpJumpIf, vm.OpJumpIfZero, vm.OpJump:
+			off := instr.Arg
+			if off == 0 {
+				off = 1
+			}
+			name := strings.ToLower(instr.Type.String())
+			switch {
+			case off < -vm.Value(i+1):
+				l.report(i, CheckJumpRange, "%s %d lands before the script and is clamped to line %d", name, instr.Arg, l.line(0))
+			case off > vm.Value(len(l.code)-i-1):
+				l.report(i, CheckJumpRange, "%s %d lands after the script and is clamped to its end", name, instr.Arg)
+			}
+		case vm.OpPush:
+			if !l.opts.Width.Contains(instr.Arg) {
+				l.report(i, CheckArgRange, "push %d does not fit in %v and is wrapped or saturated", instr.Arg, l.opts.Width)
+			}
+		case vm.OpLoad, vm.OpStore:
+			static := analysis.Options{Registers: l.opts.Registers}.StaticRegister(instr)
+			if l.opts.Registers > 0 && !static {
+				l.report(i, CheckRegisterRange, "register %d does not exist (there are %d); the index is taken from the stack",
+					instr.Arg, l.opts.Registers)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+)
+
+func lintSource(t *testing.T, src string, opts Options) []Finding {
+	code, lines, err := evo.UnmarshalLines([]byte(src))
+	require.NoError(t, err)
+	opts.Lines = lines
+	return Lint(code, opts)
+}
+
+func checks(findings []Finding) (out []string) {
+	for _, f := range findings {
+		out = append(out, f.Check)
+	}
+	return out
+}
+
+func TestLint_Clean(t *testing.T) {
+	src := `
+; stores 3, 2, 1, 0 in register 0
+push 3
+label 1
+store 0
+dec
+dup
+not
+jumpif 2
+push 1
+jumpif -8
+store 0
+`
+	assert.Empty(t, lintSource(t, src, Options{Registers: 2}))
+}
+
+func TestLint(t *testing.T) {
+	src := `pop
+call 5
+load 9
+; always jumps
+push 1
+jumpif 100
+noop
+noop
+`
+	findings := lintSource(t, src, Options{Registers: 8})
+	assert.Equal(t, []Finding{
+		{Line: 1, Iptr: 0, Check: CheckUnderflow, Message: "pop on an empty frame"},
+		{Line: 2, Iptr: 1, Check: CheckUndefinedLabel, Message: "call 5 has no matching label"},
+		{Line: 3, Iptr: 2, Check: CheckRegisterRange, Message: "register 9 does not exist (there are 8); the index is taken from the stack"},
+		{Line: 3, Iptr: 2, Check: CheckUnderflow, Message: "load on an empty frame"},
+		{Line: 6, Iptr: 4, Check: CheckJumpRange, Message: "jumpif 100 lands after the script and is clamped to its end"},
+		{Line: 7, Iptr: 5, Check: CheckUnreachable, Message: "lines 7-8 are unreachable"},
+	}, findings)
+}
+
+func TestLint_Width(t *testing.T) {
+	src := "push 1000\njump 300\n"
+	findings := lintSource(t, src, Options{Width: vm.Width16})
+	assert.Equal(t, []string{CheckJumpRange}, checks(findings))
+	findings = lintSource(t, src, Options{})
+	assert.Equal(t, Finding{Line: 1, Iptr: 0, Check: CheckArgRange, Message: "push 1000 does not fit in int8 and is wrapped or saturated"}, findings[0])
+}
+
+func TestLint_Stack(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{name: "jump before the start", src: "noop\npush 1\njumpif -9\n", want: []string{CheckJumpRange}},
+		{name: "push that does not fit", src: "push 200\nstore 0\n", want: []string{CheckArgRange}},
+		{name: "binary op with one value", src: "push 1\nadd\n", want: []string{CheckUnderflow}},
+		{name: "binary op with two values", src: "push 1\npush 2\nadd\n"},
+		{name: "negative pick", src: "push 1\npick -1\n", want: []string{CheckUnderflow}},
+		{name: "overflow", src: "push 1\ndup\ndup\ndup\ndup\ndup\ndup\ndup\ndup\n", want: []string{CheckOverflow}},
+		{name: "empty jumpif never jumps", src: "jumpif 1\nnoop\nnoop\n", want: []string{CheckUnderflow}},
+		{name: "false jumpif never jumps", src: "push 0\njumpif 1\nnoop\n"},
+		{name: "return more than the frame holds", src: "push 1\nreturn 2\n", want: []string{CheckUnderflow}},
+		{name: "callee receives call args", src: "push 1\npush 2\ncall 1\nlabel 1\nadd\nreturn 1\n"},
+		{name: "call at the end", src: "label 1\ncall 1\n", want: []string{CheckUndefinedLabel}},
+		{name: "syscall makes the frame unknown", src: "syscall 1\nadd\n"},
+		{name: "unbounded recursion", src: "label 1\ncall 1\nnoop\n", want: []string{CheckFrameOverflow}},
+		{name: "nested calls", src: "call 1\nnoop\nlabel 1\ncall 2\nreturn\nlabel 2\nreturn\n"},
+		{name: "jump past the end", src: "jump 5\n", want: []string{CheckJumpRange}},
+		{name: "jump skips code", src: "jump 1\nnoop\npush 1\n", want: []string{CheckUnreachable}},
+		{name: "true jumpifzero never jumps", src: "push 1\njumpifzero 1\nnoop\n"},
+		{name: "false jumpifzero always jumps", src: "push 0\njumpifzero 1\nnoop\nnoop\n", want: []string{CheckUnreachable}},
+		{name: "jumptolabel without a label", src: "jumptolabel 3\nnoop\n", want: []string{CheckUndefinedLabel}},
+		{name: "jumptolabel skips code", src: "jumptolabel 3\nnoop\nlabel 3\n", want: []string{CheckUnreachable}},
+		{name: "known indirect jump", src: "push 1\njumpindirect\nnoop\nnoop\n", want: []string{CheckUnreachable}},
+		{name: "unknown indirect jump", src: "load 0\njumpindirect\nnoop\nnoop\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checks(lintSource(t, tt.src, Options{CallArgs: 2})))
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package lint
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/analysis"
+)
+
+// checkStack reports unreachable code, instructions that always underflow
+// or overflow the frame and calls that may run out of frames.
+func (l *linter) checkStack() {
+	opts := analysis.Options{Registers: l.opts.Registers, CallArgs: l.opts.CallArgs, Width: l.opts.Width}
+	result := analysis.Analyze(l.code, opts)
+	frames := result.Frames
+	for _, i := range result.FrameOverflow {
+		if frames[i].MinDepth >= vm.MaxFrames {
+			l.report(i, CheckFrameOverflow, "call %d always exceeds the limit of %d frames", l.code[i].Arg, vm.MaxFrames)
+		} else {
+			l.report(i, CheckFrameOverflow, "call %d may exceed the limit of %d frames", l.code[i].Arg, vm.MaxFrames)
+		}
+	}
+	for i := 0; i < len(l.code); i++ {
+		f := frames[i]
+		if !f.Reached {
+			j := i
+			for j+1 < len(l.code) && !frames[j+1].Reached {
+				j++
+			}
+			if i == j {
+				l.report(i, CheckUnreachable, "line %d is unreachable", l.line(i))
+			} else {
+				l.report(i, CheckUnreachable, "lines %d-%d are unreachable", l.line(i), l.line(j))
+			}
+			i = j
+			continue
+		}
+		instr := l.code[i]
+		name := strings.ToLower(instr.Type.String())
+		if instr.Type == vm.OpReturn {
+			if int(instr.Arg) > f.Hi {
+				l.report(i, CheckUnderflow, "return %d hands back more values than the frame holds (at most %d)", instr.Arg, f.Hi)
+			}
+			continue
+		}
+		need, delta, ok := opts.Effect(instr)
+		if !ok {
+			continue
+		}
+		switch {
+		case instr.Type == vm.OpPick && instr.Arg < 0:
+			l.report(i, CheckUnderflow, "pick %d always underflows", instr.Arg)
+		case f.Hi < need && f.Hi == 0:
+			l.report(i, CheckUnderflow, "%s on an empty frame", name)
+		case f.Hi < need:
+			l.report(i, CheckUnderflow, "%s needs %d values but the frame holds at most %d", name, need, f.Hi)
+		case delta > 0 && f.Lo+delta > vm.FrameSize:
+			l.report(i, CheckOverflow, "%s overflows a frame that holds at least %d values", name, f.Lo)
+		}
+	}
+}
+
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Iptr < findings[j].Iptr
+	})
+}
@@ -34,61 +34,67 @@ const (
 	OpLabel
 	// OpSyscall ...
 	OpSyscall
-	// OpMax ...
+	// OpAdd ...
+	OpAdd
+	// OpSub ...
+	OpSub
+	// OpMul ...
+	OpMul
+	// OpDiv ...
+	OpDiv
+	// OpMod ...
+	OpMod
+	// OpNeg ...
+	OpNeg
+	// OpAnd ...
+	OpAnd
+	// OpOr ...
+	OpOr
+	// OpXor ...
+	OpXor
+	// OpShl ...
+	OpShl
+	// OpShr ...
+	OpShr
+	// OpDup ...
+	OpDup
+	// OpSwap ...
+	OpSwap
+	// OpOver ...
+	OpOver
+	// OpRot ...
+	OpRot
+	// OpPick ...
+	OpPick
+	// OpCodeLen pushes the length of the script.
+	OpCodeLen
+	// OpReadOp pushes the type and arg of an instruction in the script.
+	OpReadOp
+	// OpWriteOp replaces an instruction in the script.
+	OpWriteOp
+	// OpInsertOp inserts an instruction into the script.
+	OpInsertOp
+	// OpDeleteOp removes an instruction from the script.
+	OpDeleteOp
+	// OpJump jumps by its arg unconditionally.
+	OpJump
+	// OpJumpIfZero pops a value and jumps by its arg if the value is zero.
+	OpJumpIfZero
+	// OpJumpToLabel jumps past the next label matching its arg.
+	OpJumpToLabel
+	// OpJumpIndirect pops an offset and jumps by it.
+	OpJumpIndirect
+	// OpMax is the first code assigned to opcodes added with RegisterOp.
 	OpMax
 )
 
 func (op OpCode) String() string {
-	switch op {
-	case OpNoop:
-		return "Noop"
-	case OpPush:
-		return "Push"
-	case OpPop:
-		return "Pop"
-	case OpCall:
-		return "Call"
-	case OpReturn:
-		return "Return"
-	case OpJumpIf:
-		return "JumpIf"
-	case OpCompare:
-		return "Compare"
-	case OpNot:
-		return "Not"
-	case OpInc:
-		return "Inc"
-	case OpDec:
-		return "Dec"
-	case OpLoad:
-		return "Load"
-	case OpStore:
-		return "Store"
-	case OpLabel:
-		return "Label"
-	case OpSyscall:
-		return "Syscall"
-	case OpMax:
+	if info, ok := op.Info(); ok {
+		return info.Name
+	}
+	if op == OpMax {
+		// the sentinel keeps its name until an opcode is registered in its place
 		return "Max"
-	default:
-		return "OpCode(" + strconv.Itoa(int(op)) + ")"
 	}
-}
-
-// OpCodes ...
-var OpCodes = []OpCode{
-	OpNoop,
-	OpPush,
-	OpPop,
-	OpCall,
-	OpReturn,
-	OpJumpIf,
-	OpCompare,
-	OpNot,
-	OpInc,
-	OpDec,
-	OpLoad,
-	OpStore,
-	OpLabel,
-	OpSyscall,
+	return "OpCode(" + strconv.Itoa(int(op)) + ")"
 }
@@ -0,0 +1,208 @@
+// Package optimize simplifies scripts without changing what they compute.
+package optimize
+
+import (
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/analysis"
+	"github.com/jncornett/beans-engine/evo/vm/cfg"
+)
+
+// Options describes the runtime a script is simplified for.
+type Options struct {
+	// Registers is the number of registers the script runs with, or 0 if it
+	// is unknown.
+	Registers int
+	// CallArgs is the number of values a call moves into the new frame.
+	CallArgs int
+	// Overflow is the arithmetic mode of the runtime.
+	Overflow vm.Overflow
+	// Width is the width of the runtime's values.
+	Width vm.Width
+}
+
+// Simplify removes instructions that cannot affect the registers, stack
+// and faults of a run. The simplified script runs fewer instructions, so
+// iteration counts and gas use are not preserved.
+//
+// Simplify removes noops, labels that are never called, unreachable code and
+// pairs of instructions that cancel out, such as a push followed by a pop.
+// Pairs are only removed where the analysis proves that neither instruction
+// faults and nothing jumps between them. Jump offsets are adjusted to keep
+// pointing at the same instructions. Syscalls are assumed not to move the
+// instruction pointer. Scripts that read or change their own code are
+// returned unchanged, since removing instructions would change what they
+// see, and so are scripts with indirect jumps, since their offsets cannot
+// be adjusted.
+func Simplify(code []vm.Op, opts Options) []vm.Op {
+	if !supported(code) {
+		return code
+	}
+	for {
+		keep, changed := simplifyPass(code, opts)
+		if !changed {
+			return code
+		}
+		code = remap(code, keep)
+	}
+}
+
+// supported reports whether code can be simplified. It cannot if an
+// instruction uses the code or jumps by an offset taken from the stack.
+func supported(code []vm.Op) bool {
+	for _, instr := range code {
+		if e, ok := vm.EffectOf(instr, 0); ok && (e.Code != vm.AccessNone || e.Flow == vm.FlowIndirect) {
+			return false
+		}
+	}
+	return true
+}
+
+// findsLabel reports whether instr searches for a label after it, which it
+// cannot do from the end of the script.
+func findsLabel(instr vm.Op) bool {
+	return instr.Type == vm.OpCall || instr.Type == vm.OpJumpToLabel
+}
+
+// simplifyPass decides which instructions to keep.
+func simplifyPass(code []vm.Op, opts Options) (keep []bool, changed bool) {
+	aopts := analysis.Options{Registers: opts.Registers, CallArgs: opts.CallArgs, Width: opts.Width}
+	frames := analysis.Frames(code, aopts)
+	targets := entryPoints(code)
+	called := make(map[vm.Value]bool)
+	for _, instr := range code {
+		if findsLabel(instr) {
+			called[instr.Arg] = true
+		}
+	}
+	keep = make([]bool, len(code))
+	for i := 0; i < len(code); i++ {
+		instr := code[i]
+		switch {
+		case instr.Type == vm.OpLabel:
+			// labels are found by calls and jumps even if they are never
+			// reached
+			keep[i] = called[instr.Arg]
+		case instr.Type == vm.OpNoop && i == len(code)-1 && i > 0 && findsLabel(code[i-1]):
+			// a call needs an instruction after it to find its label, even
+			// if nothing returns to it
+			keep[i] = true
+		case !frames[i].Reached, instr.Type == vm.OpNoop:
+		case i+1 < len(code) && !targets[i+1] && cancels(instr, code[i+1], frames[i], opts):
+			i++
+		default:
+			keep[i] = true
+		}
+	}
+	for i := range keep {
+		if !keep[i] {
+			changed = true
+		}
+	}
+	return keep, changed
+}
+
+// cancels reports whether running a and then b leaves the machine
+// unchanged, given the frame before a.
+func cancels(a, b vm.Op, f analysis.Frame, opts Options) bool {
+	wrap := opts.Overflow == vm.OverflowWrap
+	switch {
+	case a.Type == vm.OpPush && b.Type == vm.OpPop:
+		return f.Hi < vm.FrameSize
+	case a.Type == vm.OpDup && b.Type == vm.OpPop:
+		return f.Lo >= 1 && f.Hi < vm.FrameSize
+	case a.Type == vm.OpInc && b.Type == vm.OpDec, a.Type == vm.OpDec && b.Type == vm.OpInc:
+		return wrap && f.Lo >= 1 && step(a) == step(b)
+	case a.Type == vm.OpNeg && b.Type == vm.OpNeg:
+		return wrap && f.Lo >= 1
+	case a.Type == vm.OpSwap && b.Type == vm.OpSwap:
+		return f.Lo >= 2
+	default:
+		return false
+	}
+}
+
+// step returns the amount an inc or dec changes its operand by.
+func step(instr vm.Op) vm.Value {
+	if instr.Arg == 0 {
+		return 1
+	}
+	return instr.Arg
+}
+
+// entryPoints marks the instructions that can be entered other than by
+// falling through from the previous instruction.
+func entryPoints(code []vm.Op) []bool {
+	targets := make([]bool, len(code)+1)
+	labels := vm.NewLabelIndex(code)
+	for i, instr := range code {
+		switch instr.Type {
+		case vm.OpJumpIf, vm.OpJumpIfZero, vm.OpJump:
+			targets[cfg.JumpTarget(len(code), i, instr.Arg)] = true
+		case vm.OpJumpToLabel:
+			if to, ok := cfg.CallTarget(labels, i, instr.Arg); ok {
+				targets[to] = true
+			}
+		case vm.OpCall:
+			if to, ok := cfg.CallTarget(labels, i, instr.Arg); ok {
+				targets[to] = true
+			}
+			targets[i+1] = true
+		}
+	}
+	return targets
+}
+
+// remap returns the kept instructions with jump offsets adjusted. A jump
+// that would land on the next instruction is replaced by a pop if it is
+// conditional and by a noop otherwise, since an offset of 0 skips an
+// instruction. If the last kept instruction is a call or a jump to a label
+// and instructions after it were removed, a noop is kept after it.
+func remap(code []vm.Op, keep []bool) (out []vm.Op) {
+	index := make([]int, len(code)+1)
+	n := 0
+	for i := range code {
+		index[i] = n
+		if keep[i] {
+			n++
+		}
+	}
+	// the noop kept after a final call moves the end of the script
+	callAtEnd := n > 0 && !keep[len(code)-1] && findsLabel(code[lastKept(keep)])
+	index[len(code)] = n
+	if callAtEnd {
+		index[len(code)] = n + 1
+	}
+	out = make([]vm.Op, 0, n+1)
+	for i, instr := range code {
+		if !keep[i] {
+			continue
+		}
+		switch instr.Type {
+		case vm.OpJumpIf, vm.OpJumpIfZero, vm.OpJump:
+			from := index[i]
+			offset := index[cfg.JumpTarget(len(code), i, instr.Arg)] - (from + 1)
+			switch {
+			case offset == 0 && instr.Type == vm.OpJump:
+				instr = vm.Op{Type: vm.OpNoop}
+			case offset == 0:
+				instr = vm.Op{Type: vm.OpPop}
+			default:
+				instr.Arg = vm.Value(offset)
+			}
+		}
+		out = append(out, instr)
+	}
+	if callAtEnd {
+		out = append(out, vm.Op{Type: vm.OpNoop})
+	}
+	return out
+}
+
+func lastKept(keep []bool) int {
+	for i := len(keep) - 1; i >= 0; i-- {
+		if keep[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,143 @@
+package optimize
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/genome"
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "noops and unused labels",
+			src:  "noop\nlabel 3\npush 1\nnoop\nstore 0\n",
+			want: "push 1\nstore 0\n",
+		},
+		{
+			name: "cancelling pairs",
+			src:  "push 1\npush 2\npop\ninc 3\ndec 3\ndup\npop\nneg\nneg\nstore 0\n",
+			want: "push 1\nstore 0\n",
+		},
+		{
+			name: "pairs that may fault are kept",
+			src:  "inc\ndec\nswap\nswap\n",
+			want: "inc\ndec\nswap\nswap\n",
+		},
+		{
+			name: "pairs split by a jump target are kept",
+			src:  "load 0\njumpif 1\npush 5\npop\nstore 0\n",
+			want: "load 0\njumpif 1\npush 5\npop\nstore 0\n",
+		},
+		{
+			name: "unreachable code",
+			src:  "push 1\njumpif 2\nstore 0\nstore 1\npush 7\nstore 2\n",
+			want: "push 7\nstore 2\n",
+		},
+		{
+			name: "jump offsets are remapped",
+			src:  "label 1\nnoop\nload 0\nnoop\ndec\nstore 0\nnoop\njumpif -8\n",
+			want: "load 0\ndec\nstore 0\njumpif -4\n",
+		},
+		{
+			name: "jump to the next instruction becomes a pop",
+			src:  "load 0\njumpif 1\nnoop\nstore 1\n",
+			want: "load 0\npop\nstore 1\n",
+		},
+		{
+			name: "called labels are kept",
+			src:  "call 1\nnoop\nlabel 1\nnoop\nreturn\n",
+			want: "call 1\nlabel 1\nreturn\n",
+		},
+		{
+			name: "a call keeps an instruction after it",
+			src:  "label 1\npush 0\njumpif 3\ncall 1\nnoop\nnoop\n",
+			want: "label 1\npush 0\njumpif 2\ncall 1\nnoop\n",
+		},
+		{
+			name: "unconditional jumps are remapped",
+			src:  "load 0\njumpif 3\nnoop\npush 1\njump 1\nstore 1\nstore 0\n",
+			want: "load 0\njumpif 2\npush 1\njump 1\nstore 1\nstore 0\n",
+		},
+		{
+			name: "jump to the next instruction is removed",
+			src:  "push 1\njump 0\nnoop\nstore 0\n",
+			want: "push 1\nstore 0\n",
+		},
+		{
+			name: "a jump to a label keeps an instruction after it",
+			src:  "label 1\nload 0\njumpifzero 2\njumptolabel 1\nstore 0\n",
+			want: "label 1\nload 0\njumpifzero 2\njumptolabel 1\nnoop\n",
+		},
+		{
+			name: "indirect jumps are kept",
+			src:  "noop\npush 1\njumpindirect\nstore 0\n",
+			want: "noop\npush 1\njumpindirect\nstore 0\n",
+		},
+		{
+			name: "self-modifying code is kept",
+			src:  "noop\ncodelen\nstore 0\n",
+			want: "noop\ncodelen\nstore 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := evo.Unmarshal([]byte(tt.src))
+			require.NoError(t, err)
+			want, err := evo.Unmarshal([]byte(tt.want))
+			require.NoError(t, err)
+			got := Simplify(code, Options{Registers: 4})
+			assert.Equal(t, want, got)
+			assert.NoError(t, Verify(newRuntime(vm.OverflowWrap), code, got, 4, 50, rand.New(rand.NewSource(1))))
+		})
+	}
+}
+
+func TestSimplify_Saturate(t *testing.T) {
+	code := []vm.Op{{Type: vm.OpLoad}, {Type: vm.OpInc}, {Type: vm.OpDec}, {Type: vm.OpStore}}
+	assert.Equal(t, code, Simplify(code, Options{Registers: 4, Overflow: vm.OverflowSaturate}))
+}
+
+func newRuntime(overflow vm.Overflow) *vm.Runtime {
+	return &vm.Runtime{
+		Impl:     impl.Map,
+		Hooks:    vm.RuntimeWithMaxIterations(500),
+		Overflow: overflow,
+	}
+}
+
+// TestSimplify_Random checks simplified genomes against the originals.
+func TestSimplify_Random(t *testing.T) {
+	rand.Seed(1)
+	rng := rand.New(rand.NewSource(1))
+	for _, overflow := range []vm.Overflow{vm.OverflowWrap, vm.OverflowSaturate} {
+		for _, callArgs := range []int{0, 2} {
+			r := newRuntime(overflow)
+			r.CallArgs = callArgs
+			opts := Options{Registers: 4, CallArgs: callArgs, Overflow: overflow}
+			var before, after int
+			for i := 0; i < 300; i++ {
+				code := genome.SampleN(genome.Default, 1+rng.Intn(100))
+				simplified := Simplify(code, opts)
+				before += len(code)
+				after += len(simplified)
+				if err := Verify(r, code, simplified, opts.Registers, 20, rng); err != nil {
+					original, _ := evo.Marshal(code)
+					got, _ := evo.Marshal(simplified)
+					t.Fatalf("%v\noriginal:\n%s\nsimplified:\n%s", err, original, got)
+				}
+			}
+			assert.True(t, after < before, "simplified %d instructions to %d", before, after)
+		}
+	}
+}
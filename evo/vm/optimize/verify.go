@@ -0,0 +1,75 @@
+package optimize
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+)
+
+// Verify runs original and simplified on random registers and checks that
+// they end with the same registers, stack values and faults. Trials in
+// which the original is interrupted, for example by an iteration limit, are
+// not compared. r should not be metered.
+func Verify(r *vm.Runtime, original, simplified []vm.Op, registers, trials int, rng *rand.Rand) error {
+	// wrapping a random 64-bit value samples the runtime's width uniformly
+	random := vm.Arith{Width: r.Width}
+	for trial := 0; trial < trials; trial++ {
+		input := make(vm.Register, registers)
+		for i := range input {
+			input[i] = random.Fit(int64(rng.Uint64()))
+		}
+		want, wantResult := runWith(r, original, input)
+		if wantResult.Interrupted {
+			continue
+		}
+		got, gotResult := runWith(r, simplified, input)
+		if err := compare(want, got, wantResult, gotResult); err != nil {
+			return fmt.Errorf("registers %v: %w", input, err)
+		}
+	}
+	return nil
+}
+
+func runWith(r *vm.Runtime, code []vm.Op, input vm.Register) (vm.Snapshot, vm.RunResult) {
+	state := vm.State{
+		Script:    vm.Script{Code: code},
+		Registers: append(vm.Register(nil), input...),
+	}
+	result := r.Run(&state)
+	return state.Snapshot(), result
+}
+
+func compare(want, got vm.Snapshot, wantResult, gotResult vm.RunResult) error {
+	if gotResult.Interrupted {
+		return fmt.Errorf("simplified run was interrupted after %d iterations", gotResult.Iterations)
+	}
+	if !reflect.DeepEqual(want.Registers, got.Registers) {
+		return fmt.Errorf("registers: want %v, got %v", want.Registers, got.Registers)
+	}
+	if wantStack, gotStack := stackValues(want), stackValues(got); !reflect.DeepEqual(wantStack, gotStack) {
+		return fmt.Errorf("stack: want %v, got %v", wantStack, gotStack)
+	}
+	if wantResult.Faults != gotResult.Faults {
+		return fmt.Errorf("faults: want %d, got %d", wantResult.Faults, gotResult.Faults)
+	}
+	if wantResult.Fault != nil && wantResult.Fault.Kind != gotResult.Fault.Kind {
+		return fmt.Errorf("first fault: want %v, got %v", wantResult.Fault.Kind, gotResult.Fault.Kind)
+	}
+	return nil
+}
+
+// stackValues returns the values of each frame, leaving out return
+// addresses, which move when instructions are removed. An empty stack is
+// reported as an empty base frame, which behaves the same.
+func stackValues(snap vm.Snapshot) [][]vm.Value {
+	if len(snap.Stack) == 0 {
+		return [][]vm.Value{nil}
+	}
+	out := make([][]vm.Value, len(snap.Stack))
+	for i, frame := range snap.Stack {
+		out[i] = frame.Values
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package vm
+
+import "sync"
+
+// Profiler counts how often each instruction and each opcode is executed.
+// Counts accumulate over every run of the runtimes it is attached to until
+// it is reset. A Profiler is safe for concurrent use.
+type Profiler struct {
+	mu    sync.Mutex
+	lines []int
+	ops   map[OpCode]int
+}
+
+// NewProfiler ...
+func NewProfiler() *Profiler {
+	return &Profiler{ops: make(map[OpCode]int)}
+}
+
+// Attach installs the profiler's hooks into r.
+func (p *Profiler) Attach(r *Runtime) *Runtime {
+	return r.AddHookFunc(RuntimeHookAfterStep, func(r *Runtime, state *State, result *RunResult) bool {
+		p.Hit(result.Last.Before, result.Last.Op.Type)
+		return true
+	})
+}
+
+// Hit counts an execution of op at iptr.
+func (p *Profiler) Hit(iptr int, op OpCode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grow(iptr + 1)
+	p.lines[iptr]++
+	p.ops[op]++
+}
+
+// Lines returns the hit counts indexed by instruction position. Positions
+// past the last executed instruction are omitted.
+func (p *Profiler) Lines() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.lines...)
+}
+
+// Ops returns the hit counts of each executed opcode.
+func (p *Profiler) Ops() map[OpCode]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[OpCode]int, len(p.ops))
+	for op, n := range p.ops {
+		out[op] = n
+	}
+	return out
+}
+
+// Covered returns the number of instructions among the first n that were
+// executed at least once.
+func (p *Profiler) Covered(n int) (covered int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, hits := range p.lines {
+		if i >= n {
+			break
+		}
+		if hits > 0 {
+			covered++
+		}
+	}
+	return covered
+}
+
+// Coverage returns the fraction of the first n instructions that were
+// executed at least once.
+func (p *Profiler) Coverage(n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return float64(p.Covered(n)) / float64(n)
+}
+
+// Merge adds the counts of other to p.
+func (p *Profiler) Merge(other *Profiler) {
+	lines, ops := other.Lines(), other.Ops()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grow(len(lines))
+	for i, hits := range lines {
+		p.lines[i] += hits
+	}
+	for op, n := range ops {
+		p.ops[op] += n
+	}
+}
+
+// Reset clears all counts.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lines = p.lines[:0]
+	p.ops = make(map[OpCode]int)
+}
+
+func (p *Profiler) grow(n int) {
+	for len(p.lines) < n {
+		p.lines = append(p.lines, 0)
+	}
+}
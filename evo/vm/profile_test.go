@@ -0,0 +1,45 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestProfiler(t *testing.T) {
+	profiler := vm.NewProfiler()
+	runtime := profiler.Attach(&vm.Runtime{Impl: impl.Map})
+	// countdown, then a jump over a dead instruction
+	code := append(append([]vm.Op(nil), countdown...),
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpJumpIf},
+		vm.Op{Type: vm.OpNoop},
+	)
+	for run := 1; run <= 2; run++ {
+		state := vm.State{
+			Script:    vm.Script{Code: code},
+			Registers: make(vm.Register, 1),
+		}
+		runtime.Run(&state)
+		lines := profiler.Lines()
+		assert.Len(t, lines, len(code)-1)
+		assert.Equal(t, run, lines[0])                     // push 3
+		assert.Equal(t, 3*run, lines[1])                   // label 1, once per pass of the loop
+		assert.Equal(t, 4*run, profiler.Ops()[vm.OpStore]) // 3, 2, 1 and the final 0
+	}
+	assert.Equal(t, len(code)-1, profiler.Covered(len(code)))
+	assert.InDelta(t, float64(len(code)-1)/float64(len(code)), profiler.Coverage(len(code)), 1e-9)
+
+	total := vm.NewProfiler()
+	total.Merge(profiler)
+	total.Merge(profiler)
+	assert.Equal(t, 4*4, total.Ops()[vm.OpStore])
+	assert.Equal(t, 4, total.Lines()[0])
+
+	profiler.Reset()
+	assert.Empty(t, profiler.Lines())
+	assert.Empty(t, profiler.Ops())
+}
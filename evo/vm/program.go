@@ -0,0 +1,137 @@
+package vm
+
+import "context"
+
+// Program is a script pre-decoded for a runtime. Each instruction is bound
+// to its OpImpl once, so running a program skips the per-instruction Impl
+// lookup and reuses a single Context.
+type Program struct {
+	runtime  *Runtime
+	code     []Op
+	handlers []OpImpl
+	costs    []int
+}
+
+// Compile binds code to the runtime's Impl.
+// The program must be recompiled if Impl, Costs or code changes. Programs
+// of a runtime with SelfModifying set always run through the runtime, since
+// their code may change as they run.
+func (r *Runtime) Compile(code []Op) *Program {
+	handlers := make([]OpImpl, len(code))
+	for i, instr := range code {
+		handlers[i] = r.Impl[instr.Type]
+	}
+	var costs []int
+	if r.Costs != nil {
+		costs = make([]int, len(code))
+		for i, instr := range code {
+			costs[i] = r.Costs.Cost(instr.Type)
+		}
+	}
+	return &Program{
+		runtime:  r,
+		code:     code,
+		handlers: handlers,
+		costs:    costs,
+	}
+}
+
+// CompiledFrom reports whether the program was compiled from code.
+func (p *Program) CompiledFrom(code []Op) bool {
+	if len(p.code) != len(code) {
+		return false
+	}
+	return len(code) == 0 || &p.code[0] == &code[0]
+}
+
+// runs reports whether the program can run state itself.
+func (p *Program) runs(state *State) bool {
+	return !p.runtime.SelfModifying && p.CompiledFrom(state.Script.Code)
+}
+
+// Run behaves exactly like Runtime.Run. If state does not hold the compiled
+// code, Run falls back to Runtime.Run.
+func (p *Program) Run(state *State) (result RunResult) {
+	r := p.runtime
+	if !p.runs(state) {
+		return r.Run(state)
+	}
+	ctx := runtimeContext{runtime: r, state: state}
+	if len(r.Hooks[RuntimeHookBeforeStep]) == 0 {
+		for {
+			if r.exhausted(&result) {
+				result.Interrupted = true
+				break
+			}
+			if !p.step(&ctx, &result) {
+				break
+			}
+		}
+		return result
+	}
+	for {
+		// Halt check
+		if !r.BeforeStep(state, &result) {
+			break
+		}
+		if !p.step(&ctx, &result) {
+			break
+		}
+	}
+	return result
+}
+
+// RunContext behaves exactly like Runtime.RunContext. If state does not hold
+// the compiled code, RunContext falls back to Runtime.RunContext.
+func (p *Program) RunContext(ctx context.Context, state *State) (result RunResult) {
+	r := p.runtime
+	done := ctx.Done()
+	if done == nil {
+		return p.Run(state)
+	}
+	if !p.runs(state) {
+		return r.RunContext(ctx, state)
+	}
+	rctx := runtimeContext{runtime: r, state: state}
+	before := len(r.Hooks[RuntimeHookBeforeStep]) > 0 || r.MaxIterations > 0
+	for {
+		if result.Iterations%ContextCheckInterval == 0 && canceled(ctx, done, &result) {
+			break
+		}
+		if before && !r.BeforeStep(state, &result) {
+			break
+		}
+		if !p.step(&rctx, &result) {
+			break
+		}
+	}
+	return result
+}
+
+// Step behaves exactly like Runtime.Step.
+func (p *Program) Step(state *State, result *RunResult) (ok bool) {
+	if !p.runs(state) {
+		return p.runtime.Step(state, result)
+	}
+	ctx := runtimeContext{runtime: p.runtime, state: state}
+	return p.step(&ctx, result)
+}
+
+func (p *Program) step(ctx *runtimeContext, result *RunResult) (ok bool) {
+	result.Iterations++
+	script := &ctx.state.Script
+	iptr := script.Iptr
+	if iptr >= len(p.code) {
+		p.runtime.halt(ctx.state, result)
+		return false
+	}
+	if p.costs != nil && !charge(ctx.state, p.costs[iptr], result) {
+		p.runtime.halt(ctx.state, result)
+		return false
+	}
+	script.Iptr++
+	ctx.op = p.code[iptr]
+	ctx.iptr = iptr
+	ctx.fault = nil
+	return p.runtime.execute(ctx, p.handlers[iptr], result)
+}
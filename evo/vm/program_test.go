@@ -0,0 +1,181 @@
+package vm_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/genome"
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func newState(rng *rand.Rand, code []vm.Op) *vm.State {
+	registers := make(vm.Register, 8)
+	for i := range registers {
+		registers[i] = vm.Value(rng.Intn(256) - 128)
+	}
+	return &vm.State{
+		Script:    vm.Script{Code: code},
+		Registers: registers,
+		Gas:       rng.Intn(300),
+	}
+}
+
+func TestProgram_MatchesRuntime(t *testing.T) {
+	runtimes := map[string]*vm.Runtime{
+		"default": &vm.Runtime{
+			Impl:  impl.Map,
+			Hooks: vm.RuntimeWithMaxIterations(200),
+		},
+		"halt on fault": &vm.Runtime{
+			Impl:        impl.Map,
+			Hooks:       vm.RuntimeWithMaxIterations(200),
+			HaltOnFault: true,
+		},
+		"metered": &vm.Runtime{
+			Impl:  impl.Map,
+			Hooks: vm.RuntimeWithMaxIterations(200),
+			Costs: vm.CostTable{vm.OpCall: 5, vm.OpMul: 3},
+		},
+		"iteration limit": &vm.Runtime{
+			Impl:          impl.Map,
+			MaxIterations: 200,
+		},
+		"saturate": &vm.Runtime{
+			Impl:     impl.Map,
+			Hooks:    vm.RuntimeWithMaxIterations(200),
+			CallArgs: 2,
+			Overflow: vm.OverflowSaturate,
+		},
+	}
+	for name, runtime := range runtimes {
+		t.Run(name, func(t *testing.T) {
+			rand.Seed(1)
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 500; i++ {
+				code := genome.SampleN(genome.Default, 1+rng.Intn(100))
+				seed := rng.Int63()
+				want := newState(rand.New(rand.NewSource(seed)), code)
+				got := newState(rand.New(rand.NewSource(seed)), code)
+				wantResult := runtime.Run(want)
+				gotResult := runtime.Compile(code).Run(got)
+				assert.Equal(t, wantResult, gotResult)
+				assert.Equal(t, want.Snapshot(), got.Snapshot())
+			}
+		})
+	}
+}
+
+func TestRuntime_MaxIterations(t *testing.T) {
+	code := []vm.Op{{Type: vm.OpPush, Arg: 1}, {Type: vm.OpJumpIf, Arg: -2}}
+	hooked := &vm.Runtime{Impl: impl.Map, Hooks: vm.RuntimeWithMaxIterations(50)}
+	want := hooked.Run(&vm.State{Script: vm.Script{Code: code}})
+	assert.True(t, want.Interrupted)
+	limited := &vm.Runtime{Impl: impl.Map, MaxIterations: 50}
+	assert.Equal(t, want, limited.Run(&vm.State{Script: vm.Script{Code: code}}))
+	assert.Equal(t, want, limited.Compile(code).Run(&vm.State{Script: vm.Script{Code: code}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Equal(t, want, limited.Compile(code).RunContext(ctx, &vm.State{Script: vm.Script{Code: code}}))
+}
+
+func TestProgram_Step(t *testing.T) {
+	code := []vm.Op{
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpPop},
+		vm.Op{Type: vm.OpPop},
+	}
+	runtime := &vm.Runtime{Impl: impl.Map}
+	program := runtime.Compile(code)
+	state := &vm.State{Script: vm.Script{Code: code}}
+	var result vm.RunResult
+	for program.Step(state, &result) {
+	}
+	assert.Equal(t, 4, result.Iterations)
+	assert.Equal(t, 1, result.Faults)
+	assert.Equal(t, 3, state.Script.Iptr)
+}
+
+func TestProgram_Fallback(t *testing.T) {
+	runtime := &vm.Runtime{Impl: impl.Map}
+	program := runtime.Compile([]vm.Op{vm.Op{Type: vm.OpPush, Arg: 1}})
+	state := &vm.State{Script: vm.Script{Code: []vm.Op{vm.Op{Type: vm.OpPush, Arg: 2}}}}
+	program.Run(state)
+	val, _ := state.Stack.GetValue(-1)
+	assert.Equal(t, vm.Value(2), val)
+}
+
+func TestProgram_SelfModifying(t *testing.T) {
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: vm.Value(vm.OpPush)},
+		{Type: vm.OpPush, Arg: 7},
+		{Type: vm.OpPush, Arg: 0},
+		{Type: vm.OpWriteOp},
+		{Type: vm.OpNoop},
+		{Type: vm.OpStore},
+	}
+	runtime := &vm.Runtime{Impl: impl.Map, SelfModifying: true}
+	want := &vm.State{Script: vm.Script{Code: code}, Registers: make(vm.Register, 1)}
+	got := &vm.State{Script: vm.Script{Code: code}, Registers: make(vm.Register, 1)}
+	assert.Equal(t, runtime.Run(want), runtime.Compile(code).Run(got))
+	assert.Equal(t, vm.Register{7}, got.Registers)
+	assert.Equal(t, want.Script.Code, got.Script.Code)
+}
+
+// benchmarkCode returns a fixed sample of 100 instructions, so that results
+// can be compared across changes to the registry and the generator.
+func benchmarkCode(b *testing.B) []vm.Op {
+	p, err := ioutil.ReadFile("testdata/bench.evo")
+	if err != nil {
+		b.Fatal(err)
+	}
+	code, err := evo.Unmarshal(p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return code
+}
+
+// benchmarkRun runs code in a state that is reset before each run, so that
+// only the allocations of the run are counted.
+func benchmarkRun(b *testing.B, code []vm.Op, run func(*vm.State) vm.RunResult) {
+	b.ReportAllocs()
+	state := &vm.State{Registers: make(vm.Register, 8)}
+	for i := 0; i < b.N; i++ {
+		registers := state.Registers
+		for j := range registers {
+			registers[j] = 0
+		}
+		*state = vm.State{Script: vm.Script{Code: code}, Registers: registers}
+		run(state)
+	}
+}
+
+func BenchmarkRuntime_Run(b *testing.B) {
+	code := benchmarkCode(b)
+	for _, width := range []vm.Width{vm.Width8, vm.Width64} {
+		runtime := &vm.Runtime{
+			Impl:  impl.Map,
+			Hooks: vm.RuntimeWithMaxIterations(100),
+			Width: width,
+		}
+		b.Run(width.String(), func(b *testing.B) { benchmarkRun(b, code, runtime.Run) })
+	}
+}
+
+func BenchmarkProgram_Run(b *testing.B) {
+	code := benchmarkCode(b)
+	for _, width := range []vm.Width{vm.Width8, vm.Width64} {
+		runtime := &vm.Runtime{
+			Impl:  impl.Map,
+			Hooks: vm.RuntimeWithMaxIterations(100),
+			Width: width,
+		}
+		b.Run(width.String(), func(b *testing.B) { benchmarkRun(b, code, runtime.Compile(code).Run) })
+	}
+}
@@ -0,0 +1,117 @@
+package vm
+
+import "fmt"
+
+// Recorder keeps a bounded history of the states a program passes through,
+// so that execution can be rewound. It records the state after every
+// executed instruction.
+//
+// Iterations are counted from the last Reset, which records iteration 0.
+// Only the most recent Limit states are kept.
+type Recorder struct {
+	Limit int
+	// history holds the recorded states. If Limit is set, it is a ring
+	// indexed by iteration modulo Limit; otherwise it is indexed by
+	// iteration.
+	history []State
+	oldest  int
+	// end is the iteration after the most recently recorded one.
+	end int
+}
+
+// NewRecorder ...
+func NewRecorder(limit int) *Recorder {
+	return &Recorder{Limit: limit}
+}
+
+// Attach installs the recorder's hooks into r.
+func (rec *Recorder) Attach(r *Runtime) *Runtime {
+	return r.AddHookFunc(RuntimeHookAfterStep, func(r *Runtime, state *State, result *RunResult) bool {
+		rec.Record(state)
+		return true
+	})
+}
+
+// Reset discards the history and records state as iteration 0.
+func (rec *Recorder) Reset(state *State) {
+	rec.history = rec.history[:0]
+	rec.oldest = 0
+	rec.end = 0
+	rec.Record(state)
+}
+
+// Record records state as the next iteration, replacing the oldest recorded
+// state if the history is full.
+func (rec *Recorder) Record(state *State) {
+	next := rec.end
+	i := rec.index(next)
+	if i < len(rec.history) {
+		// reuse the slot of a forgotten or evicted state
+		restore(&rec.history[i], state)
+	} else {
+		rec.history = append(rec.history, state.Clone())
+	}
+	rec.end = next + 1
+	if rec.Limit > 0 && next-rec.oldest >= rec.Limit {
+		rec.oldest = next - rec.Limit + 1
+	}
+}
+
+// index returns the position of iteration in the history.
+func (rec *Recorder) index(iteration int) int {
+	if rec.Limit > 0 {
+		return iteration % rec.Limit
+	}
+	return iteration
+}
+
+// Iteration returns the iteration of the most recently recorded state.
+func (rec *Recorder) Iteration() int {
+	return rec.end - 1
+}
+
+// Oldest returns the earliest iteration that can be restored.
+func (rec *Recorder) Oldest() int {
+	return rec.oldest
+}
+
+// Back restores state to the previous iteration.
+// Back returns false if the history does not reach back that far.
+func (rec *Recorder) Back(state *State) (ok bool) {
+	return rec.Goto(nil, state, rec.Iteration()-1) == nil
+}
+
+// Goto moves state to the given iteration. Earlier iterations are restored
+// from the history, which forgets the iterations after it. Later iterations
+// are reached by stepping r, which must have the recorder attached; r may be
+// nil if only rewinding.
+func (rec *Recorder) Goto(r *Runtime, state *State, iteration int) error {
+	if iteration < rec.oldest {
+		return fmt.Errorf("iteration %d is no longer recorded; oldest is %d", iteration, rec.oldest)
+	}
+	if iteration <= rec.Iteration() {
+		rec.end = iteration + 1
+		restore(state, &rec.history[rec.index(iteration)])
+		return nil
+	}
+	if r == nil {
+		return fmt.Errorf("iteration %d has not been reached", iteration)
+	}
+	var result RunResult
+	for rec.Iteration() < iteration {
+		// a step that halts the program may still have been recorded, as
+		// a fault with HaltOnFault is
+		if !r.Step(state, &result) && !result.Interrupted && rec.Iteration() < iteration {
+			return fmt.Errorf("program halted at iteration %d", rec.Iteration())
+		}
+		result.Interrupted = false
+	}
+	return nil
+}
+
+// restore copies saved into state, reusing the state's registers.
+func restore(state *State, saved *State) {
+	registers := append(state.Registers[:0], saved.Registers...)
+	*state = *saved
+	state.Registers = registers
+}
@@ -0,0 +1,133 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func newRecordedSession(limit int) (*vm.Recorder, *vm.Runtime, *vm.State) {
+	recorder := vm.NewRecorder(limit)
+	runtime := recorder.Attach(&vm.Runtime{
+		Impl:  impl.Map,
+		Hooks: vm.RuntimeWithMaxIterations(1000),
+	})
+	state := &vm.State{
+		Script:    vm.Script{Code: countdown},
+		Registers: make(vm.Register, 2),
+	}
+	recorder.Reset(state)
+	return recorder, runtime, state
+}
+
+func TestRecorder_Back(t *testing.T) {
+	recorder, runtime, state := newRecordedSession(0)
+	var snapshots []vm.Snapshot
+	var result vm.RunResult
+	for {
+		snapshots = append(snapshots, state.Snapshot())
+		if !runtime.Step(state, &result) {
+			break
+		}
+	}
+	assert.Equal(t, len(snapshots)-1, recorder.Iteration())
+	for i := len(snapshots) - 1; i > 0; i-- {
+		assert.Equal(t, snapshots[i], state.Snapshot())
+		require.True(t, recorder.Back(state))
+	}
+	assert.Equal(t, snapshots[0], state.Snapshot())
+	assert.False(t, recorder.Back(state))
+}
+
+func TestRecorder_Goto(t *testing.T) {
+	recorder, runtime, state := newRecordedSession(0)
+	var snapshots []vm.Snapshot
+	var result vm.RunResult
+	for {
+		snapshots = append(snapshots, state.Snapshot())
+		if !runtime.Step(state, &result) {
+			break
+		}
+	}
+	require.NoError(t, recorder.Goto(runtime, state, 5))
+	assert.Equal(t, snapshots[5], state.Snapshot())
+	assert.Equal(t, 5, recorder.Iteration())
+	require.NoError(t, recorder.Goto(runtime, state, 12))
+	assert.Equal(t, snapshots[12], state.Snapshot())
+	require.NoError(t, recorder.Goto(runtime, state, 0))
+	assert.Equal(t, snapshots[0], state.Snapshot())
+	assert.Error(t, recorder.Goto(runtime, state, len(snapshots)))
+	assert.Equal(t, snapshots[len(snapshots)-1], state.Snapshot())
+}
+
+func TestRecorder_Limit(t *testing.T) {
+	recorder, runtime, state := newRecordedSession(4)
+	runtime.Run(state)
+	last := recorder.Iteration()
+	assert.Equal(t, last-3, recorder.Oldest())
+	require.NoError(t, recorder.Goto(nil, state, last-3))
+	assert.False(t, recorder.Back(state))
+	assert.Error(t, recorder.Goto(nil, state, last-4))
+}
+
+func TestRecorder_LimitRewind(t *testing.T) {
+	recorder, runtime, state := newRecordedSession(4)
+	var snapshots []vm.Snapshot
+	var result vm.RunResult
+	for i := 0; i < 10; i++ {
+		snapshots = append(snapshots, state.Snapshot())
+		require.True(t, runtime.Step(state, &result))
+	}
+	snapshots = append(snapshots, state.Snapshot())
+	// rewinding within the ring and stepping forward again records over
+	// the forgotten iterations
+	require.NoError(t, recorder.Goto(runtime, state, 8))
+	assert.Equal(t, snapshots[8], state.Snapshot())
+	require.NoError(t, recorder.Goto(runtime, state, 10))
+	assert.Equal(t, snapshots[10], state.Snapshot())
+	assert.Equal(t, 7, recorder.Oldest())
+	for i := 9; i >= 7; i-- {
+		require.True(t, recorder.Back(state))
+		assert.Equal(t, snapshots[i], state.Snapshot())
+	}
+	assert.False(t, recorder.Back(state))
+}
+
+func TestRecorder_GotoHaltOnFault(t *testing.T) {
+	recorder := vm.NewRecorder(0)
+	runtime := recorder.Attach(&vm.Runtime{Impl: impl.Map, HaltOnFault: true})
+	state := &vm.State{Script: vm.Script{Code: []vm.Op{{Type: vm.OpPop}, {Type: vm.OpNoop}}}}
+	recorder.Reset(state)
+	// the faulting step halts the program, but it is recorded
+	require.NoError(t, recorder.Goto(runtime, state, 1))
+	assert.Equal(t, 1, recorder.Iteration())
+	assert.Error(t, recorder.Goto(runtime, state, 3), "the script ends after iteration 2")
+}
+
+func TestRecorder_BackSelfModifying(t *testing.T) {
+	recorder := vm.NewRecorder(0)
+	runtime := recorder.Attach(&vm.Runtime{Impl: impl.Map, SelfModifying: true})
+	code := []vm.Op{
+		{Type: vm.OpPush, Arg: vm.Value(vm.OpPush)},
+		{Type: vm.OpPush, Arg: 7},
+		{Type: vm.OpPush, Arg: 0},
+		{Type: vm.OpWriteOp},
+		{Type: vm.OpNoop},
+	}
+	state := &vm.State{Script: vm.Script{Code: code}}
+	recorder.Reset(state)
+	var result vm.RunResult
+	for i := 0; i < 4; i++ {
+		require.True(t, runtime.Step(state, &result))
+	}
+	require.Zero(t, result.Faults)
+	assert.Equal(t, vm.Op{Type: vm.OpPush, Arg: 7}, state.Script.Code[4])
+	// stepping back over the writeop restores the code it replaced
+	require.True(t, recorder.Back(state))
+	assert.Equal(t, code, state.Script.Code)
+	assert.Equal(t, vm.Op{Type: vm.OpNoop}, code[4], "the original code is not changed")
+}
@@ -0,0 +1,225 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// OpInfo describes an opcode to the runtime and to the tools that encode,
+// generate and check scripts.
+type OpInfo struct {
+	// Name identifies the opcode in encodings and listings. Names are
+	// unique regardless of case.
+	Name string
+	// MinArg and MaxArg bound the args that are meaningful to the opcode.
+	// The generator samples args from this range.
+	MinArg, MaxArg Value
+	// Effect describes what the opcode does to the machine. It is nil if
+	// the effect depends on the host, as it does for OpSyscall.
+	Effect *Effect
+	// Cost is the gas the opcode consumes when a CostTable does not list
+	// it. Zero means DefaultCost.
+	Cost int
+	// Impl executes the opcode.
+	Impl OpImpl
+	// Weight is how often the generator picks the opcode relative to the
+	// others. Opcodes with weight 0 are never generated.
+	Weight float64
+}
+
+// builtinOps describes the opcodes that are always registered. Their
+// handlers are registered by package impl.
+var builtinOps = [OpMax]OpInfo{
+	OpNoop:    {Name: "Noop", Effect: &Effect{}, Weight: 3},
+	OpPush:    {Name: "Push", MaxArg: 8, Effect: &Effect{Out: 1}, Weight: 2},
+	OpPop:     {Name: "Pop", Effect: &Effect{In: 1}, Weight: 2},
+	OpCall:    {Name: "Call", MaxArg: 8, Effect: &Effect{Flow: FlowCall}, Weight: 1},
+	OpReturn:  {Name: "Return", MaxArg: 2, Effect: &Effect{Flow: FlowReturn}, Weight: 1},
+	OpJumpIf:  {Name: "JumpIf", MinArg: -8, MaxArg: 8, Effect: &Effect{In: 1, Flow: FlowBranch}, Weight: 2},
+	OpCompare: {Name: "Compare", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpNot:     {Name: "Not", Effect: &Effect{In: 1, Out: 1}, Weight: 1},
+	OpInc:     {Name: "Inc", MaxArg: 2, Effect: &Effect{In: 1, Out: 1}, Weight: 2},
+	OpDec:     {Name: "Dec", MaxArg: 2, Effect: &Effect{In: 1, Out: 1}, Weight: 2},
+	OpLoad: {
+		Name:   "Load",
+		MaxArg: 8,
+		Effect: &Effect{Out: 1, Register: AccessRead, Indirect: &Effect{In: 1, Out: 1, Register: AccessRead}},
+		Weight: 2,
+	},
+	OpStore: {
+		Name:   "Store",
+		MaxArg: 8,
+		Effect: &Effect{In: 1, Out: 1, Register: AccessWrite, Indirect: &Effect{In: 1, Register: AccessWrite}},
+		Weight: 2,
+	},
+	OpLabel:   {Name: "Label", MaxArg: 8, Effect: &Effect{}, Weight: 1},
+	OpSyscall: {Name: "Syscall", MaxArg: MaxValue},
+	OpAdd:     {Name: "Add", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpSub:     {Name: "Sub", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpMul:     {Name: "Mul", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpDiv:     {Name: "Div", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpMod:     {Name: "Mod", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpNeg:     {Name: "Neg", Effect: &Effect{In: 1, Out: 1}, Weight: 1},
+	OpAnd:     {Name: "And", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpOr:      {Name: "Or", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpXor:     {Name: "Xor", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpShl:     {Name: "Shl", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpShr:     {Name: "Shr", Effect: &Effect{In: 2, Out: 1}, Weight: 1},
+	OpDup:     {Name: "Dup", Effect: &Effect{In: 1, Out: 2}, Weight: 1},
+	OpSwap:    {Name: "Swap", Effect: &Effect{In: 2, Out: 2}, Weight: 1},
+	OpOver:    {Name: "Over", Effect: &Effect{In: 2, Out: 3}, Weight: 1},
+	OpRot:     {Name: "Rot", Effect: &Effect{In: 3, Out: 3}, Weight: 1},
+	OpPick:    {Name: "Pick", MaxArg: 3, Effect: &Effect{ArgIn: true, Out: 1}, Weight: 1},
+	// the self-modifying opcodes only run if Runtime.SelfModifying is set,
+	// so they are not generated by default
+	OpCodeLen:  {Name: "CodeLen", Effect: &Effect{Out: 1, Code: AccessRead}},
+	OpReadOp:   {Name: "ReadOp", Effect: &Effect{In: 1, Out: 2, Code: AccessRead}},
+	OpWriteOp:  {Name: "WriteOp", Effect: &Effect{In: 3, Code: AccessWrite}},
+	OpInsertOp: {Name: "InsertOp", Effect: &Effect{In: 3, Code: AccessWrite}},
+	OpDeleteOp: {Name: "DeleteOp", Effect: &Effect{In: 1, Code: AccessWrite}},
+
+	OpJump:         {Name: "Jump", MinArg: -8, MaxArg: 8, Effect: &Effect{Flow: FlowJump}, Weight: 1},
+	OpJumpIfZero:   {Name: "JumpIfZero", MinArg: -8, MaxArg: 8, Effect: &Effect{In: 1, Flow: FlowBranchIfZero}, Weight: 2},
+	OpJumpToLabel:  {Name: "JumpToLabel", MaxArg: 8, Effect: &Effect{Flow: FlowJumpToLabel}, Weight: 1},
+	OpJumpIndirect: {Name: "JumpIndirect", Effect: &Effect{In: 1, Flow: FlowIndirect}, Weight: 1},
+}
+
+// maxOpCode is the largest opcode that fits the byte an instruction's type
+// is encoded in.
+const maxOpCode = math.MaxInt8
+
+var (
+	// opInfos is indexed by opcode.
+	opInfos  []OpInfo
+	opByName = make(map[string]OpCode)
+	opImpl   = make(Impl)
+)
+
+func init() {
+	for op, info := range builtinOps {
+		opInfos = append(opInfos, info)
+		opByName[strings.ToLower(info.Name)] = OpCode(op)
+	}
+}
+
+// RegisterOp adds an opcode described by info and returns its code. Codes
+// are assigned in order, starting at OpMax. Opcodes should be registered
+// while the program initializes, before any runtime, encoder or tool uses
+// them; RegisterOp is not safe to call concurrently with them.
+func RegisterOp(info OpInfo) (OpCode, error) {
+	switch {
+	case info.Name == "" || strings.IndexFunc(info.Name, unicode.IsSpace) >= 0:
+		return 0, fmt.Errorf("invalid opcode name %q", info.Name)
+	case info.MinArg > info.MaxArg:
+		return 0, fmt.Errorf("opcode %s: arg range [%d, %d] is empty", info.Name, info.MinArg, info.MaxArg)
+	case info.Cost < 0 || info.Weight < 0:
+		return 0, fmt.Errorf("opcode %s: cost and weight must not be negative", info.Name)
+	case info.Impl == nil:
+		return 0, fmt.Errorf("opcode %s has no implementation", info.Name)
+	case len(opInfos) > maxOpCode:
+		return 0, fmt.Errorf("opcode %s: at most %d opcodes can be registered", info.Name, maxOpCode+1)
+	}
+	name := strings.ToLower(info.Name)
+	if _, ok := opByName[name]; ok {
+		return 0, fmt.Errorf("opcode %s is already registered", info.Name)
+	}
+	op := OpCode(len(opInfos))
+	opInfos = append(opInfos, info)
+	opByName[name] = op
+	opImpl[op] = info.Impl
+	return op, nil
+}
+
+// MustRegisterOp is like RegisterOp, but panics if the opcode cannot be
+// registered.
+func MustRegisterOp(info OpInfo) OpCode {
+	op, err := RegisterOp(info)
+	if err != nil {
+		panic(err)
+	}
+	return op
+}
+
+// SetOpImpl sets the handler of a registered opcode. It returns false if op
+// is not registered.
+func SetOpImpl(op OpCode, fn OpImpl) (ok bool) {
+	if op < 0 || int(op) >= len(opInfos) {
+		return false
+	}
+	opInfos[op].Impl = fn
+	if fn == nil {
+		delete(opImpl, op)
+	} else {
+		opImpl[op] = fn
+	}
+	return true
+}
+
+// RegisteredImpl returns the handlers of the registered opcodes. The map is
+// the registry's own rather than a copy, so it includes opcodes that are
+// registered later, and changing it changes every runtime that uses it.
+func RegisteredImpl() Impl {
+	return opImpl
+}
+
+// Info returns the description of a registered opcode.
+func (op OpCode) Info() (info OpInfo, ok bool) {
+	if op < 0 || int(op) >= len(opInfos) {
+		return OpInfo{}, false
+	}
+	return opInfos[op], true
+}
+
+// LookupOp returns the opcode with the given name, ignoring case.
+func LookupOp(name string) (op OpCode, ok bool) {
+	op, ok = opByName[strings.ToLower(name)]
+	return op, ok
+}
+
+// RegisteredOps returns the registered opcodes in increasing order.
+func RegisteredOps() []OpCode {
+	out := make([]OpCode, len(opInfos))
+	for i := range out {
+		out[i] = OpCode(i)
+	}
+	return out
+}
+
+// OpCodes lists the built-in opcodes.
+//
+// Deprecated: OpCodes does not include the opcodes added with RegisterOp.
+// Use RegisteredOps instead.
+var OpCodes = func() []OpCode {
+	out := make([]OpCode, OpMax)
+	for i := range out {
+		out[i] = OpCode(i)
+	}
+	return out
+}()
+
+// saveOps records the registry and returns a function that restores it, so
+// that tests can register opcodes without leaking them into other tests.
+func saveOps() (restore func()) {
+	infos := append([]OpInfo(nil), opInfos...)
+	byName := make(map[string]OpCode, len(opByName))
+	for name, op := range opByName {
+		byName[name] = op
+	}
+	impls := make(Impl, len(opImpl))
+	for op, fn := range opImpl {
+		impls[op] = fn
+	}
+	return func() {
+		opInfos = infos
+		opByName = byName
+		// opImpl is shared with the runtimes, so it is restored in place
+		for op := range opImpl {
+			delete(opImpl, op)
+		}
+		for op, fn := range impls {
+			opImpl[op] = fn
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestRegisterOp(t *testing.T) {
+	defer vm.SaveOps()()
+	// square replaces the top value with its square
+	square, err := vm.RegisterOp(vm.OpInfo{
+		Name:   "Square",
+		Effect: &vm.Effect{In: 1, Out: 1},
+		Cost:   3,
+		Weight: 1,
+		Impl: func(ctx vm.Context) {
+			val, ok := ctx.PopValue()
+			if !ok {
+				ctx.Fault(vm.FaultStackUnderflow)
+				return
+			}
+			ctx.Stack().PushValue(ctx.Runtime().Arith().Mul(val, val))
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, square >= vm.OpMax)
+	assert.Equal(t, "Square", square.String())
+	op, ok := vm.LookupOp("square")
+	assert.True(t, ok)
+	assert.Equal(t, square, op)
+	assert.Contains(t, vm.RegisteredOps(), square)
+	assert.Equal(t, 3, vm.CostTable{}.Cost(square))
+	assert.Equal(t, 1, vm.CostTable{square: 1}.Cost(square))
+	e, ok := vm.EffectOf(vm.Op{Type: square}, 0)
+	assert.True(t, ok)
+	assert.Equal(t, vm.Effect{In: 1, Out: 1}, e)
+
+	// runtimes built on impl.Map pick up the new opcode
+	r := vm.Runtime{Impl: impl.Map}
+	state := vm.State{Script: vm.Script{Code: []vm.Op{
+		{Type: vm.OpPush, Arg: 5},
+		{Type: square},
+	}}}
+	result := r.Run(&state)
+	assert.Zero(t, result.Faults)
+	top, _ := state.Stack.GetValue(-1)
+	assert.Equal(t, vm.Value(25), top)
+
+	_, err = vm.RegisterOp(vm.OpInfo{Name: "SQUARE", Impl: impl.OpNoop})
+	assert.Error(t, err, "names are unique regardless of case")
+	_, err = vm.RegisterOp(vm.OpInfo{Name: "cube"})
+	assert.Error(t, err, "an opcode needs an implementation")
+	_, err = vm.RegisterOp(vm.OpInfo{Name: "shift left", Impl: impl.OpNoop})
+	assert.Error(t, err)
+	_, err = vm.RegisterOp(vm.OpInfo{Name: "cube", MinArg: 1, Impl: impl.OpNoop})
+	assert.Error(t, err)
+}
+
+func TestOpCodes_Builtin(t *testing.T) {
+	for _, op := range vm.RegisteredOps() {
+		info, ok := op.Info()
+		require.True(t, ok)
+		assert.NotNil(t, info.Impl, "%v has no handler", op)
+		got, ok := vm.LookupOp(info.Name)
+		assert.True(t, ok)
+		assert.Equal(t, op, got)
+	}
+	_, ok := vm.OpCode(-1).Info()
+	assert.False(t, ok)
+	assert.Equal(t, "OpCode(-1)", vm.OpCode(-1).String())
+	assert.Equal(t, "Max", vm.OpMax.String())
+	assert.Equal(t, vm.RegisteredOps()[:vm.OpMax], vm.OpCodes)
+}
+
+func TestRegisterOp_Restored(t *testing.T) {
+	// TestRegisterOp leaves no trace, so it can run again
+	_, ok := vm.LookupOp("square")
+	assert.False(t, ok)
+	assert.Len(t, vm.RegisteredOps(), int(vm.OpMax))
+	assert.Len(t, impl.Map, int(vm.OpMax))
+}
@@ -1,54 +1,133 @@
 package vm
 
+import "context"
+
 // RuntimeHook ...
 type RuntimeHook int
 
 const (
-	// RuntimeHookBeforeStep ...
+	// RuntimeHookBeforeStep runs in Run before each instruction is fetched.
 	RuntimeHookBeforeStep RuntimeHook = iota
+	// RuntimeHookAfterStep runs after each instruction is executed.
+	RuntimeHookAfterStep
+	// RuntimeHookOnCall runs after an instruction pushes a frame.
+	RuntimeHookOnCall
+	// RuntimeHookOnReturn runs after an instruction pops a frame.
+	RuntimeHookOnReturn
+	// RuntimeHookOnFault runs after an instruction raises a fault.
+	RuntimeHookOnFault
+	// RuntimeHookOnHalt runs when the program halts because it ran past the
+	// end of the script, ran out of gas or faulted with HaltOnFault set.
+	// Its result is ignored.
+	RuntimeHookOnHalt
 )
 
-// RuntimeHandler ...
+// RuntimeHandler is called with the state and result of the current run.
+// The instruction that was just executed is described by RunResult.Last.
+// Returning false interrupts the run. The hooks that run after an
+// instruction are all called even if one of them interrupts.
 type RuntimeHandler func(*Runtime, *State, *RunResult) (ok bool)
 
 // RuntimeHookConfig ...
 type RuntimeHookConfig map[RuntimeHook][]RuntimeHandler
 
+// SyscallFunc is a host function that is invoked by OpSyscall.
+type SyscallFunc func(ctx Context)
+
+// Syscalls maps syscall IDs to host functions.
+type Syscalls map[Value]SyscallFunc
+
 // Runtime ...
 type Runtime struct {
-	Impl  Impl
-	Hooks map[RuntimeHook][]RuntimeHandler
+	Impl     Impl
+	Hooks    map[RuntimeHook][]RuntimeHandler
+	Syscalls Syscalls
+	// CallArgs is the number of values OpCall moves from the caller's frame
+	// into the new frame.
+	CallArgs int
+	// Overflow selects how arithmetic handles results that do not fit in
+	// Width.
+	Overflow Overflow
+	// Width is the number of bits arithmetic keeps its results within. It
+	// also bounds the args OpPush and OpReadOp push.
+	Width Width
+	// Costs meters execution when it is not nil. Each instruction consumes
+	// its cost from State.Gas, and execution halts when the gas runs out.
+	Costs CostTable
+	// HaltOnFault stops execution at the first fault instead of counting it
+	// and moving on to the next instruction.
+	HaltOnFault bool
+	// SelfModifying enables the opcodes that read and change Script.Code,
+	// such as OpReadOp and OpInsertOp. They fault with FaultIllegalOp when
+	// it is not set. Changes are made to a copy of the code, so the code a
+	// state started with is never modified.
+	SelfModifying bool
+	// MaxCodeLen is the length self-modifying scripts may grow to. Zero
+	// means no limit.
+	MaxCodeLen int
+	// MaxIterations interrupts a run once it has executed this many steps.
+	// Zero means no limit. It is checked by BeforeStep, but unlike a hook it
+	// does not take a Program off its fast path.
+	MaxIterations int
 }
 
 // RunResult ...
 type RunResult struct {
 	Interrupted bool
 	Iterations  int
+	// Faults is the number of instructions that raised a fault.
+	Faults int
+	// Fault is the first fault that was raised, if any.
+	Fault *Fault `toml:",omitempty" json:",omitempty"`
+	// GasUsed is the total cost of the executed instructions.
+	GasUsed int
+	// OutOfGas is set if execution halted because State.Gas could not cover
+	// the next instruction.
+	OutOfGas bool
+	// Last describes the most recently executed instruction.
+	Last StepEvent
+	// Loop is set if cycle detection interrupted the run.
+	Loop *Loop `toml:",omitempty" json:",omitempty"`
+	// Err is set if RunContext was interrupted because its context was
+	// canceled or its deadline passed. It is the context's error.
+	Err error `toml:"-" json:"-"`
+}
+
+// StepEvent describes an executed instruction.
+type StepEvent struct {
+	Op Op
+	// Before is the position of the instruction.
+	Before int
+	// After is the instruction pointer after executing it.
+	After int
+	Fault *Fault `toml:",omitempty" json:",omitempty"`
 }
 
 type runtimeContext struct {
 	runtime *Runtime
 	state   *State
 	op      Op
+	iptr    int
+	fault   *Fault
 }
 
-func (ctx runtimeContext) Instr() Op {
+func (ctx *runtimeContext) Instr() Op {
 	return ctx.Op()
 }
 
-func (ctx runtimeContext) Op() Op {
+func (ctx *runtimeContext) Op() Op {
 	return ctx.op
 }
 
-func (ctx runtimeContext) Stack() *Stack {
+func (ctx *runtimeContext) Stack() *Stack {
 	return &ctx.state.Stack
 }
 
-func (ctx runtimeContext) Script() *Script {
+func (ctx *runtimeContext) Script() *Script {
 	return &ctx.state.Script
 }
 
-func (ctx runtimeContext) PopFrame() (*StackFrame, bool) {
+func (ctx *runtimeContext) PopFrame() (*StackFrame, bool) {
 	frame, ok := ctx.state.Stack.Get(-1)
 	if !ok {
 		return nil, false
@@ -59,7 +138,7 @@ func (ctx runtimeContext) PopFrame() (*StackFrame, bool) {
 	return frame, true
 }
 
-func (ctx runtimeContext) PopValue() (Value, bool) {
+func (ctx *runtimeContext) PopValue() (Value, bool) {
 	frame, ok := ctx.state.Stack.Get(-1)
 	if !ok {
 		return 0, false
@@ -74,10 +153,29 @@ func (ctx runtimeContext) PopValue() (Value, bool) {
 	return val, true
 }
 
-func (ctx runtimeContext) Registers() *Register {
+func (ctx *runtimeContext) Registers() *Register {
 	return &ctx.state.Registers
 }
 
+func (ctx *runtimeContext) Runtime() *Runtime {
+	return ctx.runtime
+}
+
+func (ctx *runtimeContext) Fault(kind FaultKind) {
+	if ctx.fault != nil {
+		return // only the first fault of an instruction is reported
+	}
+	ctx.fault = &Fault{Kind: kind, Iptr: ctx.iptr, Op: ctx.op}
+}
+
+func (ctx *runtimeContext) call(fn OpImpl) {
+	if fn == nil {
+		ctx.Fault(FaultIllegalOp)
+		return
+	}
+	fn(ctx)
+}
+
 // Context ...
 type Context interface {
 	Instr() Op
@@ -86,45 +184,176 @@ type Context interface {
 	PopValue() (Value, bool)
 	Script() *Script
 	Registers() *Register
+	Runtime() *Runtime
+	// Fault reports an error in the current instruction.
+	Fault(kind FaultKind)
 }
 
 // Run ...
 func (r *Runtime) Run(state *State) (result RunResult) {
 	for {
 		// Halt check
-		if !r.hook(RuntimeHookBeforeStep, state, &result) {
-			result.Interrupted = true
+		if !r.BeforeStep(state, &result) {
+			break
+		}
+		if !r.Step(state, &result) {
+			break
+		}
+	}
+	return result
+}
+
+// ContextCheckInterval is the number of instructions RunContext executes
+// between checks of its context.
+const ContextCheckInterval = 256
+
+// RunContext is like Run, but also stops when ctx is canceled or its deadline
+// passes. The context is checked before the first instruction and then every
+// ContextCheckInterval instructions. If it stops the run, the result is
+// marked as interrupted and RunResult.Err holds the context's error.
+func (r *Runtime) RunContext(ctx context.Context, state *State) (result RunResult) {
+	done := ctx.Done()
+	if done == nil {
+		return r.Run(state) // the context can never be canceled
+	}
+	for {
+		if result.Iterations%ContextCheckInterval == 0 && canceled(ctx, done, &result) {
+			break
+		}
+		if !r.BeforeStep(state, &result) {
 			break
 		}
-		result.Iterations++
-		if !r.Step(state) {
+		if !r.Step(state, &result) {
 			break
 		}
 	}
 	return result
 }
 
-// Step executes a single instruction in the state.
+// canceled reports whether the context that owns done is finished, and
+// records why in result.
+func canceled(ctx context.Context, done <-chan struct{}, result *RunResult) bool {
+	select {
+	case <-done:
+		result.Interrupted = true
+		result.Err = ctx.Err()
+		return true
+	default:
+		return false
+	}
+}
+
+// BeforeStep runs the RuntimeHookBeforeStep hooks, as Run does before each
+// instruction. If a hook interrupts the run, BeforeStep marks result as
+// interrupted and returns false. Callers that drive Step themselves should
+// call BeforeStep before each step.
+func (r *Runtime) BeforeStep(state *State, result *RunResult) (ok bool) {
+	if r.exhausted(result) || !r.hook(RuntimeHookBeforeStep, state, result) {
+		result.Interrupted = true
+		return false
+	}
+	return true
+}
+
+// exhausted reports whether the run has taken MaxIterations steps.
+func (r *Runtime) exhausted(result *RunResult) bool {
+	return r.MaxIterations > 0 && result.Iterations >= r.MaxIterations
+}
+
+// Step executes a single instruction in the state and records the outcome in result.
 // Step returns true if the program is not halted.
-func (r *Runtime) Step(state *State) (ok bool) {
-	next, ok := state.Script.Next()
+func (r *Runtime) Step(state *State, result *RunResult) (ok bool) {
+	result.Iterations++
+	iptr := state.Script.Iptr
+	next, ok := state.Script.Peek()
 	if !ok {
+		r.halt(state, result)
 		return false
 	}
-	r.Exec(state, next)
-	return true
+	if r.Costs != nil && !charge(state, r.Costs.Cost(next.Type), result) {
+		r.halt(state, result)
+		return false
+	}
+	state.Script.Iptr++
+	ctx := runtimeContext{
+		runtime: r,
+		state:   state,
+		op:      next,
+		iptr:    iptr,
+	}
+	return r.execute(&ctx, r.Impl[next.Type], result)
 }
 
 // Exec executes an arbitrary instruction against state.
-func (r *Runtime) Exec(state *State, instr Op) {
+// Exec returns the fault raised by the instruction, if any.
+func (r *Runtime) Exec(state *State, instr Op) (fault *Fault) {
 	ctx := runtimeContext{
 		runtime: r,
 		state:   state,
 		op:      instr,
+		iptr:    state.Script.Iptr,
+	}
+	ctx.call(r.Impl[instr.Type])
+	return ctx.fault
+}
+
+// execute runs fn in ctx and records the outcome in result.
+// execute returns false if the program should halt.
+func (r *Runtime) execute(ctx *runtimeContext, fn OpImpl, result *RunResult) (ok bool) {
+	state := ctx.state
+	depth := frameDepth(&state.Stack)
+	ctx.call(fn)
+	if ctx.fault != nil {
+		result.Faults++
+		if result.Fault == nil {
+			result.Fault = ctx.fault
+		}
+	}
+	result.Last = StepEvent{
+		Op:     ctx.op,
+		Before: ctx.iptr,
+		After:  state.Script.Iptr,
+		Fault:  ctx.fault,
+	}
+	if len(r.Hooks) > 0 && !r.afterStep(state, depth, result) {
+		result.Interrupted = true
+		return false
+	}
+	if ctx.fault != nil && r.HaltOnFault {
+		r.halt(state, result)
+		return false
+	}
+	return true
+}
+
+// afterStep runs the hooks for the instruction described by result.Last.
+// depth is the frame depth before the instruction was executed. Every hook
+// observes the instruction, even if an earlier one interrupts the run.
+func (r *Runtime) afterStep(state *State, depth int, result *RunResult) (ok bool) {
+	ok = true
+	if result.Last.Fault != nil {
+		ok = r.notify(RuntimeHookOnFault, state, result) && ok
 	}
-	if fn, ok := r.Impl[instr.Type]; ok {
-		fn(&ctx)
+	switch after := frameDepth(&state.Stack); {
+	case after > depth:
+		ok = r.notify(RuntimeHookOnCall, state, result) && ok
+	case after < depth:
+		ok = r.notify(RuntimeHookOnReturn, state, result) && ok
+	}
+	return r.notify(RuntimeHookAfterStep, state, result) && ok
+}
+
+// halt runs the halt hooks.
+func (r *Runtime) halt(state *State, result *RunResult) {
+	r.notify(RuntimeHookOnHalt, state, result)
+}
+
+// frameDepth counts the frames on the stack, including the implicit base frame.
+func frameDepth(stack *Stack) int {
+	if stack.Max == 0 {
+		return 1
 	}
+	return int(stack.Max)
 }
 
 func (r *Runtime) hook(rh RuntimeHook, state *State, result *RunResult) (ok bool) {
@@ -138,6 +367,15 @@ func (r *Runtime) hook(rh RuntimeHook, state *State, result *RunResult) (ok bool
 	return ok
 }
 
+// notify is like hook, but runs every handler even if one returns false.
+func (r *Runtime) notify(rh RuntimeHook, state *State, result *RunResult) (ok bool) {
+	ok = true
+	for _, h := range r.Hooks[rh] {
+		ok = h(r, state, result) && ok
+	}
+	return ok
+}
+
 // AddHookFunc ...
 func (r *Runtime) AddHookFunc(rh RuntimeHook, h RuntimeHandler) *Runtime {
 	if r.Hooks == nil {
@@ -164,7 +402,34 @@ func (r *Runtime) RemoveHooks(rh RuntimeHook) *Runtime {
 	return r
 }
 
-// RuntimeWithMaxIterations ...
+// Arith returns the arithmetic of the runtime's overflow mode and width.
+func (r *Runtime) Arith() Arith {
+	return Arith{Overflow: r.Overflow, Width: r.Width}
+}
+
+// Syscall looks up the host function registered under id.
+func (r *Runtime) Syscall(id Value) (fn SyscallFunc, ok bool) {
+	fn, ok = r.Syscalls[id]
+	return fn, ok
+}
+
+// AddSyscall registers fn under id, replacing any existing registration.
+func (r *Runtime) AddSyscall(id Value, fn SyscallFunc) *Runtime {
+	if r.Syscalls == nil {
+		r.Syscalls = make(Syscalls)
+	}
+	r.Syscalls[id] = fn
+	return r
+}
+
+// RemoveSyscall ...
+func (r *Runtime) RemoveSyscall(id Value) *Runtime {
+	delete(r.Syscalls, id)
+	return r
+}
+
+// RuntimeWithMaxIterations interrupts runs after max steps. Setting
+// Runtime.MaxIterations does the same without a hook.
 func RuntimeWithMaxIterations(max uint) RuntimeHookConfig {
 	return RuntimeHookConfig{
 		RuntimeHookBeforeStep: []RuntimeHandler{
@@ -0,0 +1,144 @@
+package vm_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestRuntime_Hooks(t *testing.T) {
+	code := []vm.Op{
+		vm.Op{Type: vm.OpCall, Arg: 1},
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpJumpIf, Arg: 3},
+		vm.Op{Type: vm.OpLabel, Arg: 1},
+		vm.Op{Type: vm.OpPop},
+		vm.Op{Type: vm.OpReturn},
+	}
+	var events []string
+	record := func(name string) vm.RuntimeHandler {
+		return func(r *vm.Runtime, state *vm.State, result *vm.RunResult) bool {
+			last := result.Last
+			events = append(events, fmt.Sprintf("%s %v %d->%d", name, last.Op.Type, last.Before, last.After))
+			return true
+		}
+	}
+	runtime := &vm.Runtime{Impl: impl.Map}
+	runtime.AddHook(vm.RuntimeHookConfig{
+		vm.RuntimeHookAfterStep: []vm.RuntimeHandler{record("step")},
+		vm.RuntimeHookOnCall:    []vm.RuntimeHandler{record("call")},
+		vm.RuntimeHookOnReturn:  []vm.RuntimeHandler{record("return")},
+		vm.RuntimeHookOnFault:   []vm.RuntimeHandler{record("fault")},
+		vm.RuntimeHookOnHalt:    []vm.RuntimeHandler{record("halt")},
+	})
+	want := []string{
+		"call Call 0->4",
+		"step Call 0->4",
+		"fault Pop 4->5",
+		"step Pop 4->5",
+		"return Return 5->1",
+		"step Return 5->1",
+		"step Push 1->2",
+		"step JumpIf 2->6",
+		"halt JumpIf 2->6",
+	}
+	for name, run := range map[string]func(*vm.State) vm.RunResult{
+		"runtime": runtime.Run,
+		"program": runtime.Compile(code).Run,
+	} {
+		t.Run(name, func(t *testing.T) {
+			events = nil
+			result := run(&vm.State{Script: vm.Script{Code: code}})
+			assert.Equal(t, want, events)
+			assert.False(t, result.Interrupted)
+		})
+	}
+}
+
+func TestRuntime_HookInterrupts(t *testing.T) {
+	code := []vm.Op{
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpPop},
+		vm.Op{Type: vm.OpPop},
+		vm.Op{Type: vm.OpPush, Arg: 2},
+	}
+	halted := 0
+	runtime := (&vm.Runtime{Impl: impl.Map}).
+		AddHookFunc(vm.RuntimeHookOnFault, func(*vm.Runtime, *vm.State, *vm.RunResult) bool {
+			return false
+		}).
+		AddHookFunc(vm.RuntimeHookOnHalt, func(*vm.Runtime, *vm.State, *vm.RunResult) bool {
+			halted++
+			return true
+		})
+	state := &vm.State{Script: vm.Script{Code: code}}
+	result := runtime.Run(state)
+	assert.True(t, result.Interrupted)
+	assert.Equal(t, 3, state.Script.Iptr)
+	assert.Equal(t, 0, halted)
+	runtime.RemoveHooks(vm.RuntimeHookOnFault)
+	result = runtime.Run(state)
+	assert.False(t, result.Interrupted)
+	assert.Equal(t, 1, halted)
+}
+
+func TestRuntime_RunContext(t *testing.T) {
+	loop := []vm.Op{
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpJumpIf, Arg: -2},
+	}
+	runtime := &vm.Runtime{Impl: impl.Map}
+	var cancel context.CancelFunc
+	runtime.AddHookFunc(vm.RuntimeHookAfterStep, func(r *vm.Runtime, state *vm.State, result *vm.RunResult) bool {
+		if result.Iterations == 1000 {
+			cancel()
+		}
+		return true
+	})
+	for name, run := range map[string]func(context.Context, *vm.State) vm.RunResult{
+		"runtime": runtime.RunContext,
+		"program": runtime.Compile(loop).RunContext,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			result := run(ctx, &vm.State{Script: vm.Script{Code: loop}})
+			assert.True(t, result.Interrupted)
+			assert.Equal(t, context.Canceled, result.Err)
+			// the cancellation is noticed at the next check
+			assert.Equal(t, 4*vm.ContextCheckInterval, result.Iterations)
+
+			result = run(ctx, &vm.State{Script: vm.Script{Code: loop}})
+			assert.Zero(t, result.Iterations, "a canceled context runs nothing")
+		})
+	}
+}
+
+func TestRuntime_RunContext_Deadline(t *testing.T) {
+	loop := []vm.Op{
+		vm.Op{Type: vm.OpPush, Arg: 1},
+		vm.Op{Type: vm.OpJumpIf, Arg: -2},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	result := (&vm.Runtime{Impl: impl.Map}).RunContext(ctx, &vm.State{Script: vm.Script{Code: loop}})
+	assert.True(t, result.Interrupted)
+	assert.Equal(t, context.DeadlineExceeded, result.Err)
+}
+
+func TestRuntime_RunContext_Halts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	state := &vm.State{Script: vm.Script{Code: countdown}, Registers: make(vm.Register, 2)}
+	want := (&vm.Runtime{Impl: impl.Map}).Run(&vm.State{Script: vm.Script{Code: countdown}, Registers: make(vm.Register, 2)})
+	got := (&vm.Runtime{Impl: impl.Map}).RunContext(ctx, state)
+	assert.Equal(t, want, got)
+	assert.NoError(t, got.Err)
+}
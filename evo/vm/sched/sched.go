@@ -0,0 +1,241 @@
+// Package sched runs many VMs on one runtime, interleaving them in time
+// slices and letting them exchange values through mailboxes.
+package sched
+
+import "github.com/jncornett/beans-engine/evo/vm"
+
+// Syscall IDs installed by a Scheduler.
+const (
+	// SyscallSelf pushes the ID of the calling process: ( -- id ).
+	SyscallSelf vm.Value = 1
+	// SyscallSend pops a process ID and a value and posts the value to that
+	// process's mailbox. It pushes 1 if the message was delivered and 0 if
+	// the process does not exist or its mailbox is full: ( val id -- ok ).
+	SyscallSend vm.Value = 2
+	// SyscallReceive takes the oldest message from the caller's mailbox and
+	// pushes its value and the ID of its sender: ( -- val from ). If the
+	// mailbox is empty the process blocks until a message arrives, and the
+	// receive runs again when it is resumed.
+	SyscallReceive vm.Value = 3
+)
+
+const (
+	defaultQuantum     = 16
+	defaultMailboxSize = 8
+)
+
+// Status ...
+type Status int
+
+const (
+	// Runnable processes are given time slices.
+	Runnable Status = iota
+	// Blocked processes wait for a message.
+	Blocked
+	// Halted processes have finished or were interrupted by a hook.
+	Halted
+)
+
+func (s Status) String() string {
+	switch s {
+	case Runnable:
+		return "runnable"
+	case Blocked:
+		return "blocked"
+	case Halted:
+		return "halted"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is a value sent between processes.
+type Message struct {
+	From  int
+	Value vm.Value
+}
+
+// Process is a VM run by a Scheduler.
+type Process struct {
+	ID    int
+	State *vm.State
+	// Result accumulates the outcome of every slice the process ran.
+	Result vm.RunResult
+	// Weight is the number of quanta the process runs per slice.
+	Weight int
+	Status Status
+	// Mailbox holds the messages that have not been received yet, oldest
+	// first.
+	Mailbox []Message
+}
+
+// Scheduler interleaves processes in round-robin time slices. Each process
+// runs for Quantum instructions times its weight per round, or until it
+// blocks or halts.
+//
+// The scheduler installs its syscalls into the runtime it is created with,
+// so a runtime should only be shared by one scheduler. The syscalls fault
+// with FaultIllegalOp if the runtime runs them outside of a slice. Cycle detection
+// should not be used with a scheduler, since a process's mailbox is not part
+// of its machine state.
+type Scheduler struct {
+	Runtime *vm.Runtime
+	// Quantum is the number of instructions in a slice of weight 1.
+	Quantum int
+	// MailboxSize is the number of messages a mailbox holds.
+	MailboxSize int
+
+	procs   []*Process
+	current *Process
+}
+
+// New returns a scheduler that runs processes on r.
+func New(r *vm.Runtime) *Scheduler {
+	s := &Scheduler{
+		Runtime:     r,
+		Quantum:     defaultQuantum,
+		MailboxSize: defaultMailboxSize,
+	}
+	r.AddSyscall(SyscallSelf, s.self)
+	r.AddSyscall(SyscallSend, s.send)
+	r.AddSyscall(SyscallReceive, s.receive)
+	return s
+}
+
+// Spawn adds a process that runs state with the given weight. Weights below
+// 1 are treated as 1.
+func (s *Scheduler) Spawn(state *vm.State, weight int) *Process {
+	if weight < 1 {
+		weight = 1
+	}
+	p := &Process{ID: len(s.procs), State: state, Weight: weight}
+	s.procs = append(s.procs, p)
+	return p
+}
+
+// Processes returns the processes ordered by ID.
+func (s *Scheduler) Processes() []*Process {
+	return s.procs
+}
+
+// Process returns the process with the given ID.
+func (s *Scheduler) Process(id int) (p *Process, ok bool) {
+	if id < 0 || id >= len(s.procs) {
+		return nil, false
+	}
+	return s.procs[id], true
+}
+
+// Round gives every runnable process one slice. It returns false if no
+// process was runnable.
+func (s *Scheduler) Round() (ran bool) {
+	for _, p := range s.procs {
+		if p.Status != Runnable {
+			continue
+		}
+		ran = true
+		s.slice(p)
+	}
+	return ran
+}
+
+// Run runs rounds until no process is runnable or max rounds have run,
+// whichever comes first. A max of 0 means no limit. Run returns the number
+// of rounds that ran. Processes that are still blocked when Run returns are
+// deadlocked unless more messages are sent to them.
+func (s *Scheduler) Run(max int) (rounds int) {
+	for max == 0 || rounds < max {
+		if !s.Round() {
+			break
+		}
+		rounds++
+	}
+	return rounds
+}
+
+func (s *Scheduler) slice(p *Process) {
+	s.current = p
+	defer func() { s.current = nil }()
+	for n := s.Quantum * p.Weight; n > 0 && p.Status == Runnable; n-- {
+		if !s.Runtime.BeforeStep(p.State, &p.Result) || !s.Runtime.Step(p.State, &p.Result) {
+			p.Status = Halted
+		}
+	}
+}
+
+// Send posts a message to the process with the given ID and wakes it if it
+// is blocked. Send fails if there is no such process or its mailbox is full.
+func (s *Scheduler) Send(from, to int, val vm.Value) (ok bool) {
+	p, ok := s.Process(to)
+	if !ok || len(p.Mailbox) >= s.MailboxSize {
+		return false
+	}
+	p.Mailbox = append(p.Mailbox, Message{From: from, Value: val})
+	if p.Status == Blocked {
+		p.Status = Runnable
+	}
+	return true
+}
+
+// process returns the process whose slice is running. It faults if there is
+// none, which happens when the runtime is used without the scheduler.
+func (s *Scheduler) process(ctx vm.Context) (p *Process, ok bool) {
+	if s.current == nil {
+		ctx.Fault(vm.FaultIllegalOp)
+		return nil, false
+	}
+	return s.current, true
+}
+
+func (s *Scheduler) self(ctx vm.Context) {
+	p, ok := s.process(ctx)
+	if !ok {
+		return
+	}
+	push(ctx, vm.Value(p.ID))
+}
+
+func (s *Scheduler) send(ctx vm.Context) {
+	p, ok := s.process(ctx)
+	if !ok {
+		return
+	}
+	to, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return
+	}
+	val, ok := ctx.PopValue()
+	if !ok {
+		ctx.Fault(vm.FaultStackUnderflow)
+		return
+	}
+	var sent vm.Value
+	if s.Send(p.ID, int(to), val) {
+		sent = 1
+	}
+	push(ctx, sent)
+}
+
+func (s *Scheduler) receive(ctx vm.Context) {
+	p, ok := s.process(ctx)
+	if !ok {
+		return
+	}
+	if len(p.Mailbox) == 0 {
+		// run the receive again once a message arrives
+		ctx.Script().Iptr--
+		p.Status = Blocked
+		return
+	}
+	msg := p.Mailbox[0]
+	p.Mailbox = append(p.Mailbox[:0], p.Mailbox[1:]...)
+	push(ctx, msg.Value)
+	push(ctx, vm.Value(msg.From))
+}
+
+func push(ctx vm.Context, val vm.Value) {
+	if !ctx.Stack().PushValue(val) {
+		ctx.Fault(vm.FaultStackOverflow)
+	}
+}
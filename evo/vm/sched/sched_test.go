@@ -0,0 +1,121 @@
+package sched
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/encoding/evo"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func newState(t *testing.T, src string) *vm.State {
+	code, err := evo.Unmarshal([]byte(src))
+	require.NoError(t, err)
+	return &vm.State{
+		Script:    vm.Script{Code: code},
+		Registers: make(vm.Register, 2),
+	}
+}
+
+func topValues(state *vm.State) []vm.Value {
+	frame, ok := state.Stack.Get(-1)
+	if !ok {
+		return nil
+	}
+	return frame.Values()
+}
+
+// echo answers the first message it receives with the value plus one.
+const echo = `
+syscall 3
+swap
+inc
+swap
+syscall 2
+pop
+`
+
+func TestScheduler_PingPong(t *testing.T) {
+	s := New(&vm.Runtime{Impl: impl.Map})
+	server := s.Spawn(newState(t, echo), 1)
+	client := s.Spawn(newState(t, `
+push 41
+push 0
+syscall 2
+pop
+syscall 3
+pop
+store 0
+`), 1)
+	assert.Equal(t, 2, s.Run(0))
+	assert.Equal(t, Halted, server.Status)
+	assert.Equal(t, Halted, client.Status)
+	assert.Equal(t, vm.Register{42, 0}, client.State.Registers)
+	assert.Zero(t, server.Result.Faults)
+	assert.Zero(t, client.Result.Faults)
+	// the server blocked once instead of spinning while it waited
+	assert.Equal(t, len(server.State.Script.Code)+2, server.Result.Iterations)
+}
+
+func TestScheduler_MailboxFull(t *testing.T) {
+	s := New(&vm.Runtime{Impl: impl.Map})
+	s.MailboxSize = 1
+	s.Spawn(newState(t, ""), 1)
+	sender := s.Spawn(newState(t, `
+push 1
+push 0
+syscall 2
+push 2
+push 0
+syscall 2
+push 3
+push 9
+syscall 2
+`), 1)
+	s.Run(0)
+	assert.Equal(t, []vm.Value{1, 0, 0}, topValues(sender.State))
+	p, _ := s.Process(0)
+	assert.Equal(t, []Message{{From: 1, Value: 1}}, p.Mailbox)
+}
+
+func TestScheduler_Weights(t *testing.T) {
+	s := New(&vm.Runtime{Impl: impl.Map})
+	s.Quantum = 4
+	loop := "push 1\njumpif -2\n"
+	light := s.Spawn(newState(t, loop), 1)
+	heavy := s.Spawn(newState(t, loop), 3)
+	assert.Equal(t, 5, s.Run(5))
+	assert.Equal(t, 20, light.Result.Iterations)
+	assert.Equal(t, 60, heavy.Result.Iterations)
+}
+
+func TestScheduler_Deadlock(t *testing.T) {
+	s := New(&vm.Runtime{Impl: impl.Map})
+	a := s.Spawn(newState(t, echo), 1)
+	b := s.Spawn(newState(t, "syscall 1\nsyscall 3\n"), 1)
+	assert.Equal(t, 1, s.Run(0))
+	assert.Equal(t, Blocked, a.Status)
+	assert.Equal(t, Blocked, b.Status)
+	assert.Equal(t, []vm.Value{1}, topValues(b.State))
+	require.True(t, s.Send(-1, a.ID, 7))
+	assert.Equal(t, Runnable, a.Status)
+	s.Run(0)
+	assert.Equal(t, Halted, a.Status)
+	assert.Equal(t, Blocked, b.Status)
+	assert.Equal(t, 1, b.State.Script.Iptr, "a blocked receive runs again when it is resumed")
+}
+
+func TestScheduler_OutsideSlice(t *testing.T) {
+	runtime := &vm.Runtime{Impl: impl.Map}
+	New(runtime)
+	for _, src := range []string{"syscall 1", "push 1\npush 0\nsyscall 2", "syscall 3"} {
+		state := newState(t, src)
+		result := runtime.Run(state)
+		if assert.NotNil(t, result.Fault, src) {
+			assert.Equal(t, vm.FaultIllegalOp, result.Fault.Kind, src)
+		}
+	}
+}
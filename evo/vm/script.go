@@ -1,9 +1,12 @@
 package vm
 
+import "sort"
+
 // Script ...
 type Script struct {
-	Code []Op
-	Iptr int
+	Code   []Op
+	Iptr   int
+	labels *LabelIndex
 }
 
 // Peek ...
@@ -23,32 +26,73 @@ func (script *Script) Next() (instr Op, ok bool) {
 	return instr, ok
 }
 
-// FindNextLabel ...
+// FindNextLabel finds the next label with value val at or after the
+// instruction pointer, wrapping around to the start of the script.
 func (script *Script) FindNextLabel(val Value) (iptr int, ok bool) {
-	// FIXME linear runtime
-	for i := script.Iptr; i < len(script.Code); i++ {
-		instr := script.Code[i]
-		if instr.Type != OpLabel {
-			continue
-		}
-		if instr.Arg != val {
-			continue
-		}
-		return i, true
+	return script.Labels().Find(val, script.Iptr)
+}
+
+// Labels returns the label index of the script, rebuilding it if Code has
+// been replaced since it was last built.
+func (script *Script) Labels() *LabelIndex {
+	if !script.labels.Valid(script.Code) {
+		script.labels = NewLabelIndex(script.Code)
 	}
-	if script.Iptr < len(script.Code) {
-		for i := 0; i < script.Iptr; i++ {
-			instr := script.Code[i]
-			if instr.Type != OpLabel {
-				continue
-			}
-			if instr.Arg != val {
-				continue
-			}
-			return i, true
-		}
+	return script.labels
+}
+
+// Invalidate discards the label index. It must be called after modifying
+// Code in place; replacing or resizing Code is detected automatically.
+func (script *Script) Invalidate() {
+	script.labels = nil
+}
+
+// SetOp replaces the instruction at pos. Like InsertOp and DeleteOp, it
+// changes a copy of Code, so code shared with other scripts is not
+// affected. It fails if pos is outside the script.
+func (script *Script) SetOp(pos int, op Op) (ok bool) {
+	if pos < 0 || pos >= len(script.Code) {
+		return false
+	}
+	code := append([]Op(nil), script.Code...)
+	code[pos] = op
+	script.Code = code
+	return true
+}
+
+// InsertOp inserts op before the instruction at pos, or appends it if pos
+// is the length of the script. If the instruction pointer is at or after
+// pos, it moves with the instruction it points at. It fails if pos is
+// outside the script.
+func (script *Script) InsertOp(pos int, op Op) (ok bool) {
+	if pos < 0 || pos > len(script.Code) {
+		return false
+	}
+	code := make([]Op, 0, len(script.Code)+1)
+	code = append(code, script.Code[:pos]...)
+	code = append(code, op)
+	script.Code = append(code, script.Code[pos:]...)
+	if script.Iptr >= pos {
+		script.Iptr++
+	}
+	return true
+}
+
+// DeleteOp removes the instruction at pos. If the instruction pointer is
+// after pos, it moves with the instruction it points at; if it points at
+// the deleted instruction, it points at the one that followed it. It fails
+// if pos is outside the script.
+func (script *Script) DeleteOp(pos int) (ok bool) {
+	if pos < 0 || pos >= len(script.Code) {
+		return false
+	}
+	code := make([]Op, 0, len(script.Code)-1)
+	code = append(code, script.Code[:pos]...)
+	script.Code = append(code, script.Code[pos+1:]...)
+	if script.Iptr > pos {
+		script.Iptr--
 	}
-	return 0, false
+	return true
 }
 
 // JumpOffset ...
@@ -71,3 +115,47 @@ func (script Script) Done() bool {
 func (script *Script) Reset() {
 	script.Iptr = 0
 }
+
+// LabelIndex maps label values to the positions of their OpLabel
+// instructions.
+type LabelIndex struct {
+	code      []Op
+	positions map[Value][]int
+}
+
+// NewLabelIndex ...
+func NewLabelIndex(code []Op) *LabelIndex {
+	positions := make(map[Value][]int)
+	for i, instr := range code {
+		if instr.Type != OpLabel {
+			continue
+		}
+		positions[instr.Arg] = append(positions[instr.Arg], i)
+	}
+	return &LabelIndex{code: code, positions: positions}
+}
+
+// Valid reports whether the index was built for code.
+func (idx *LabelIndex) Valid(code []Op) bool {
+	if idx == nil || len(idx.code) != len(code) {
+		return false
+	}
+	return len(code) == 0 || &idx.code[0] == &code[0]
+}
+
+// Find returns the position of the first label with value val at or after
+// from, wrapping around to the start of the code.
+func (idx *LabelIndex) Find(val Value, from int) (iptr int, ok bool) {
+	if from >= len(idx.code) {
+		return 0, false
+	}
+	positions := idx.positions[val]
+	if len(positions) == 0 {
+		return 0, false
+	}
+	i := sort.SearchInts(positions, from)
+	if i < len(positions) {
+		return positions[i], true
+	}
+	return positions[0], true
+}
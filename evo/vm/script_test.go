@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -88,3 +89,147 @@ func TestScript_Next(t *testing.T) {
 	}
 
 }
+
+// linearFindNextLabel is the reference implementation of FindNextLabel.
+func linearFindNextLabel(script *Script, val Value) (iptr int, ok bool) {
+	for i := script.Iptr; i < len(script.Code); i++ {
+		instr := script.Code[i]
+		if instr.Type == OpLabel && instr.Arg == val {
+			return i, true
+		}
+	}
+	if script.Iptr < len(script.Code) {
+		for i := 0; i < script.Iptr; i++ {
+			instr := script.Code[i]
+			if instr.Type == OpLabel && instr.Arg == val {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestScript_FindNextLabel(t *testing.T) {
+	code := []Op{
+		Op{Type: OpLabel, Arg: 1},
+		Op{Type: OpPush, Arg: 2},
+		Op{Type: OpLabel, Arg: 2},
+		Op{Type: OpLabel, Arg: 1},
+		Op{Type: OpNoop},
+	}
+	tests := []struct {
+		name     string
+		iptr     int
+		val      Value
+		wantIptr int
+		wantOk   bool
+	}{
+		{name: "at start", iptr: 0, val: 1, wantIptr: 0, wantOk: true},
+		{name: "ahead", iptr: 1, val: 1, wantIptr: 3, wantOk: true},
+		{name: "wrap around", iptr: 4, val: 2, wantIptr: 2, wantOk: true},
+		{name: "not a label", iptr: 0, val: 3},
+		{name: "push arg is not a label", iptr: 0, val: 2, wantIptr: 2, wantOk: true},
+		{name: "at end", iptr: 5, val: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := Script{Code: code, Iptr: tt.iptr}
+			iptr, ok := script.FindNextLabel(tt.val)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantIptr, iptr)
+		})
+	}
+}
+
+func TestScript_FindNextLabel_MatchesLinear(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 100; n++ {
+		script := Script{Code: randomLabelCode(rng, rng.Intn(50))}
+		for i := 0; i <= len(script.Code)+1; i++ {
+			script.Iptr = i
+			for val := Value(0); val < 5; val++ {
+				wantIptr, wantOk := linearFindNextLabel(&script, val)
+				iptr, ok := script.FindNextLabel(val)
+				assert.Equal(t, wantOk, ok)
+				assert.Equal(t, wantIptr, iptr)
+			}
+		}
+	}
+}
+
+func TestScript_Labels_Invalidate(t *testing.T) {
+	script := Script{Code: []Op{Op{Type: OpLabel, Arg: 1}}}
+	_, ok := script.FindNextLabel(1)
+	assert.True(t, ok)
+	script.Code[0].Arg = 2
+	script.Invalidate()
+	_, ok = script.FindNextLabel(1)
+	assert.False(t, ok)
+	script.Code = append(script.Code, Op{Type: OpLabel, Arg: 1})
+	iptr, ok := script.FindNextLabel(1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, iptr)
+}
+
+func TestScript_EditOps(t *testing.T) {
+	code := []Op{{Type: OpLabel, Arg: 1}, {Type: OpPush}, {Type: OpLabel, Arg: 2}}
+	script := Script{Code: code, Iptr: 1}
+	iptr, _ := script.FindNextLabel(2)
+	assert.Equal(t, 2, iptr)
+
+	assert.True(t, script.InsertOp(1, Op{Type: OpNoop}))
+	assert.Equal(t, 2, script.Iptr, "the pointer moves with the instruction it points at")
+	iptr, _ = script.FindNextLabel(2)
+	assert.Equal(t, 3, iptr, "the label index follows the change")
+	assert.True(t, script.InsertOp(4, Op{Type: OpPop}))
+	assert.Equal(t, 2, script.Iptr)
+	assert.False(t, script.InsertOp(6, Op{}))
+
+	assert.True(t, script.DeleteOp(2))
+	assert.Equal(t, 2, script.Iptr, "the pointer moves to the instruction after a deleted one")
+	assert.True(t, script.DeleteOp(0))
+	assert.Equal(t, 1, script.Iptr)
+	assert.False(t, script.DeleteOp(3))
+
+	assert.True(t, script.SetOp(0, Op{Type: OpInc}))
+	assert.False(t, script.SetOp(-1, Op{}))
+	assert.Equal(t, []Op{{Type: OpInc}, {Type: OpLabel, Arg: 2}, {Type: OpPop}}, script.Code)
+	assert.Equal(t, []Op{{Type: OpLabel, Arg: 1}, {Type: OpPush}, {Type: OpLabel, Arg: 2}}, code, "edits never change the original code")
+}
+
+func randomLabelCode(rng *rand.Rand, n int) []Op {
+	code := make([]Op, n)
+	for i := range code {
+		code[i] = Op{Type: OpNoop, Arg: Value(rng.Intn(5))}
+		if rng.Intn(4) == 0 {
+			code[i].Type = OpLabel
+		}
+	}
+	return code
+}
+
+func benchmarkCallHeavyScript() Script {
+	code := randomLabelCode(rand.New(rand.NewSource(1)), 10000)
+	for i := range code {
+		if code[i].Type == OpLabel {
+			code[i].Type = OpNoop
+		}
+	}
+	code[len(code)/4] = Op{Type: OpLabel, Arg: 1}
+	return Script{Code: code, Iptr: len(code) / 2}
+}
+
+func BenchmarkScript_FindNextLabel(b *testing.B) {
+	b.Run("linear", func(b *testing.B) {
+		script := benchmarkCallHeavyScript()
+		for i := 0; i < b.N; i++ {
+			linearFindNextLabel(&script, 1)
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		script := benchmarkCallHeavyScript()
+		for i := 0; i < b.N; i++ {
+			script.FindNextLabel(1)
+		}
+	})
+}
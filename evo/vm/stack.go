@@ -30,6 +30,64 @@ func (frame *StackFrame) Get(idx int) (val Value, ok bool) {
 	return frame.Data[i], true
 }
 
+// Set ...
+func (frame *StackFrame) Set(idx int, val Value) (ok bool) {
+	i, ok := offsetIndex(int(frame.Max), idx)
+	if !ok {
+		return false
+	}
+	frame.Data[i] = val
+	return true
+}
+
+// Dup pushes a copy of the top value: ( a -- a a ).
+func (frame *StackFrame) Dup() (ok bool) {
+	return frame.Pick(0)
+}
+
+// Over pushes a copy of the second value: ( a b -- a b a ).
+func (frame *StackFrame) Over() (ok bool) {
+	return frame.Pick(1)
+}
+
+// Pick pushes a copy of the nth value below the top: ( xn ... x0 -- xn ... x0 xn ).
+func (frame *StackFrame) Pick(n int) (ok bool) {
+	if n < 0 {
+		return false
+	}
+	val, ok := frame.Get(-1 - n)
+	if !ok {
+		return false
+	}
+	return frame.Push(val)
+}
+
+// Swap exchanges the top two values: ( a b -- b a ).
+func (frame *StackFrame) Swap() (ok bool) {
+	a, ok := frame.Get(-2)
+	if !ok {
+		return false
+	}
+	b, _ := frame.Get(-1)
+	frame.Set(-2, b)
+	frame.Set(-1, a)
+	return true
+}
+
+// Rot rotates the third value to the top: ( a b c -- b c a ).
+func (frame *StackFrame) Rot() (ok bool) {
+	a, ok := frame.Get(-3)
+	if !ok {
+		return false
+	}
+	b, _ := frame.Get(-2)
+	c, _ := frame.Get(-1)
+	frame.Set(-3, b)
+	frame.Set(-2, c)
+	frame.Set(-1, a)
+	return true
+}
+
 // Pop ...
 func (frame *StackFrame) Pop(n int) (popped int) {
 	if n < 0 {
@@ -85,6 +143,71 @@ func (stack *Stack) Push(iptr int) (pushed bool) {
 	return stack.push(iptr)
 }
 
+// Call pushes a new frame that returns to iptr, moving the top n values of
+// the current frame into it. If the current frame holds fewer than n values,
+// all of them are moved.
+func (stack *Stack) Call(iptr int, n int) (pushed bool) {
+	if !stack.ensureBaseFrame() {
+		return false
+	}
+	if stack.Max >= uint(len(stack.Data)) {
+		return false
+	}
+	caller := &stack.Data[stack.Max-1]
+	args := caller.Values()
+	if n < 0 {
+		n = 0
+	}
+	if n < len(args) {
+		args = args[len(args)-n:]
+	}
+	callee := StackFrame{Return: iptr, Max: uint(len(args))}
+	copy(callee.Data[:], args)
+	for i := range args {
+		args[i] = 0 // the values now belong to the callee
+	}
+	caller.Pop(len(args))
+	stack.Data[stack.Max] = callee
+	stack.Max++
+	return true
+}
+
+// Return pops the current frame and moves its top n values onto the caller
+// frame, dropping any that do not fit. Return reports the return address of
+// the popped frame, and fails if there is no caller frame.
+func (stack *Stack) Return(n int) (iptr int, ok bool) {
+	if stack.Max < 2 {
+		return 0, false
+	}
+	callee := stack.Data[stack.Max-1]
+	stack.Max--
+	results := callee.Values()
+	if n < 0 {
+		n = 0
+	}
+	if n < len(results) {
+		results = results[len(results)-n:]
+	}
+	caller := &stack.Data[stack.Max-1]
+	for _, val := range results {
+		if !caller.Push(val) {
+			break
+		}
+	}
+	return callee.Return, true
+}
+
+// ShiftReturns adds delta to the return addresses of the frames that are at
+// or after from. It keeps the frames returning to the same instructions
+// after code is inserted or deleted before them.
+func (stack *Stack) ShiftReturns(from, delta int) {
+	for i := uint(0); i < stack.Max; i++ {
+		if stack.Data[i].Return >= from {
+			stack.Data[i].Return += delta
+		}
+	}
+}
+
 // PushValue ...
 func (stack *Stack) PushValue(val Value) (pushed bool) {
 	stack.ensureBaseFrame()
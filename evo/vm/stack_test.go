@@ -183,3 +183,229 @@ func makeStackFrame(ret int, vals []Value) StackFrame {
 	copy(frame.Data[:], vals)
 	return frame
 }
+
+func TestStack_Call(t *testing.T) {
+	tests := []struct {
+		name       string
+		stack      []StackFrame
+		iptr       int
+		n          int
+		wantPushed bool
+		wantStack  []StackFrame
+	}{
+		{
+			name:       "empty stack",
+			iptr:       3,
+			wantPushed: true,
+			wantStack: []StackFrame{
+				makeStackFrame(0, nil),
+				makeStackFrame(3, nil),
+			},
+		},
+		{
+			name:       "pass args",
+			stack:      []StackFrame{makeStackFrame(0, []Value{1, 2, 3})},
+			iptr:       3,
+			n:          2,
+			wantPushed: true,
+			wantStack: []StackFrame{
+				makeStackFrame(0, []Value{1}),
+				makeStackFrame(3, []Value{2, 3}),
+			},
+		},
+		{
+			name:       "too few args",
+			stack:      []StackFrame{makeStackFrame(0, []Value{1})},
+			iptr:       3,
+			n:          2,
+			wantPushed: true,
+			wantStack: []StackFrame{
+				makeStackFrame(0, nil),
+				makeStackFrame(3, []Value{1}),
+			},
+		},
+		{
+			name:       "max frames",
+			stack:      make([]StackFrame, MaxFrames),
+			iptr:       3,
+			wantPushed: false,
+			wantStack:  make([]StackFrame, MaxFrames),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stack := Stack{Max: uint(len(tt.stack))}
+			copy(stack.Data[:], tt.stack)
+			pushed := stack.Call(tt.iptr, tt.n)
+			assert.Equal(t, tt.wantPushed, pushed)
+			assert.Equal(t, tt.wantStack, stack.Frames())
+		})
+	}
+}
+
+func TestStack_Return(t *testing.T) {
+	tests := []struct {
+		name      string
+		stack     []StackFrame
+		n         int
+		wantIptr  int
+		wantOk    bool
+		wantStack []StackFrame
+	}{
+		{
+			name: "empty stack",
+		},
+		{
+			name:      "base frame",
+			stack:     []StackFrame{makeStackFrame(0, []Value{1})},
+			n:         1,
+			wantStack: []StackFrame{makeStackFrame(0, []Value{1})},
+		},
+		{
+			name: "hand back results",
+			stack: []StackFrame{
+				makeStackFrame(0, []Value{1}),
+				makeStackFrame(5, []Value{2, 3, 4}),
+			},
+			n:         2,
+			wantIptr:  5,
+			wantOk:    true,
+			wantStack: []StackFrame{makeStackFrame(0, []Value{1, 3, 4})},
+		},
+		{
+			name: "too few results",
+			stack: []StackFrame{
+				makeStackFrame(0, []Value{1}),
+				makeStackFrame(5, []Value{2}),
+			},
+			n:         2,
+			wantIptr:  5,
+			wantOk:    true,
+			wantStack: []StackFrame{makeStackFrame(0, []Value{1, 2})},
+		},
+		{
+			name: "caller frame full",
+			stack: []StackFrame{
+				makeStackFrame(0, []Value{1, 2, 3, 4, 5, 6, 7}),
+				makeStackFrame(5, []Value{8, 9}),
+			},
+			n:         2,
+			wantIptr:  5,
+			wantOk:    true,
+			wantStack: []StackFrame{makeStackFrame(0, []Value{1, 2, 3, 4, 5, 6, 7, 8})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stack := Stack{Max: uint(len(tt.stack))}
+			copy(stack.Data[:], tt.stack)
+			iptr, ok := stack.Return(tt.n)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantIptr, iptr)
+			assert.Equal(t, tt.wantStack, stack.Frames())
+		})
+	}
+}
+
+func TestStack_CallReturn(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		var stack Stack
+		stack.PushValue(1)
+		assert.True(t, stack.Call(10, 1))
+		stack.PushValue(2)
+		assert.True(t, stack.Call(20, 2))
+		assert.Equal(t, []StackFrame{
+			makeStackFrame(0, nil),
+			makeStackFrame(10, nil),
+			makeStackFrame(20, []Value{1, 2}),
+		}, stack.Frames())
+		iptr, ok := stack.Return(2)
+		assert.True(t, ok)
+		assert.Equal(t, 20, iptr)
+		iptr, ok = stack.Return(1)
+		assert.True(t, ok)
+		assert.Equal(t, 10, iptr)
+		assert.Equal(t, []StackFrame{makeStackFrame(0, []Value{2})}, stack.Frames())
+		_, ok = stack.Return(1)
+		assert.False(t, ok)
+	})
+	t.Run("recursive", func(t *testing.T) {
+		var stack Stack
+		stack.PushValue(0)
+		depth := 0
+		for stack.Call(7, 1) {
+			val, _ := stack.GetValue(-1)
+			stack.PopValues(1)
+			stack.PushValue(val + 1)
+			depth++
+		}
+		assert.Equal(t, MaxFrames-1, depth)
+		for {
+			iptr, ok := stack.Return(1)
+			if !ok {
+				break
+			}
+			assert.Equal(t, 7, iptr)
+			depth--
+		}
+		assert.Equal(t, 0, depth)
+		assert.Equal(t, []StackFrame{makeStackFrame(0, []Value{MaxFrames - 1})}, stack.Frames())
+	})
+}
+
+func TestStackFrame_Manipulation(t *testing.T) {
+	full := []Value{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := []struct {
+		name      string
+		stack     []Value
+		fn        func(*StackFrame) bool
+		wantOk    bool
+		wantStack []Value
+	}{
+		{name: "dup", stack: []Value{1, 2}, fn: (*StackFrame).Dup, wantOk: true, wantStack: []Value{1, 2, 2}},
+		{name: "dup empty", fn: (*StackFrame).Dup},
+		{name: "dup full", stack: full, fn: (*StackFrame).Dup, wantStack: full},
+		{name: "swap", stack: []Value{1, 2, 3}, fn: (*StackFrame).Swap, wantOk: true, wantStack: []Value{1, 3, 2}},
+		{name: "swap short", stack: []Value{1}, fn: (*StackFrame).Swap, wantStack: []Value{1}},
+		{name: "over", stack: []Value{1, 2}, fn: (*StackFrame).Over, wantOk: true, wantStack: []Value{1, 2, 1}},
+		{name: "over short", stack: []Value{1}, fn: (*StackFrame).Over, wantStack: []Value{1}},
+		{name: "rot", stack: []Value{1, 2, 3}, fn: (*StackFrame).Rot, wantOk: true, wantStack: []Value{2, 3, 1}},
+		{name: "rot deep", stack: []Value{9, 1, 2, 3}, fn: (*StackFrame).Rot, wantOk: true, wantStack: []Value{9, 2, 3, 1}},
+		{name: "rot short", stack: []Value{1, 2}, fn: (*StackFrame).Rot, wantStack: []Value{1, 2}},
+		{
+			name:      "pick 0",
+			stack:     []Value{1, 2, 3},
+			fn:        func(frame *StackFrame) bool { return frame.Pick(0) },
+			wantOk:    true,
+			wantStack: []Value{1, 2, 3, 3},
+		},
+		{
+			name:      "pick 2",
+			stack:     []Value{1, 2, 3},
+			fn:        func(frame *StackFrame) bool { return frame.Pick(2) },
+			wantOk:    true,
+			wantStack: []Value{1, 2, 3, 1},
+		},
+		{
+			name:      "pick past bottom",
+			stack:     []Value{1, 2, 3},
+			fn:        func(frame *StackFrame) bool { return frame.Pick(3) },
+			wantStack: []Value{1, 2, 3},
+		},
+		{
+			name:      "pick negative",
+			stack:     []Value{1, 2, 3},
+			fn:        func(frame *StackFrame) bool { return frame.Pick(-1) },
+			wantStack: []Value{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := StackFrame{Max: uint(len(tt.stack))}
+			copy(frame.Data[:], tt.stack)
+			ok := tt.fn(&frame)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantStack, frame.Values())
+		})
+	}
+}
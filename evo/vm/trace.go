@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// TraceRecord describes one executed instruction.
+type TraceRecord struct {
+	// Iteration counts the instructions executed in the run, starting at 1.
+	Iteration int `json:"iteration"`
+	Iptr      int `json:"iptr"`
+	// Op is the name of the opcode.
+	Op  string `json:"op"`
+	Arg Value  `json:"arg"`
+	// Top is the value on top of the stack after the instruction, if any.
+	Top *Value `json:"top,omitempty"`
+	// Depth is the number of frames on the stack after the instruction. As
+	// for the call and return hooks and the analysis package, the base frame
+	// counts even before it is pushed.
+	Depth int `json:"depth"`
+	// Registers holds the new values of the registers the instruction changed.
+	Registers map[int]Value `json:"registers,omitempty"`
+	// Fault is the kind of fault the instruction raised, if any.
+	Fault string `json:"fault,omitempty"`
+}
+
+// Tracer writes a TraceRecord for every executed instruction as a line of
+// JSON.
+type Tracer struct {
+	enc       *json.Encoder
+	registers Register
+	err       error
+}
+
+// NewTracer ...
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{enc: json.NewEncoder(w)}
+}
+
+// Attach installs the tracer's hooks into r.
+func (t *Tracer) Attach(r *Runtime) *Runtime {
+	return r.AddHookFunc(RuntimeHookAfterStep, func(r *Runtime, state *State, result *RunResult) bool {
+		t.Trace(state, result)
+		return true
+	})
+}
+
+// Reset makes state the baseline that register changes are reported
+// against. It should be called before tracing a new state.
+func (t *Tracer) Reset(state *State) {
+	t.registers = append(t.registers[:0], state.Registers...)
+}
+
+// Trace writes the record of the instruction described by result.Last.
+// Nothing is written once writing has failed.
+func (t *Tracer) Trace(state *State, result *RunResult) {
+	if t.err != nil {
+		return
+	}
+	last := result.Last
+	rec := TraceRecord{
+		Iteration: result.Iterations,
+		Iptr:      last.Before,
+		Op:        last.Op.Type.String(),
+		Arg:       last.Op.Arg,
+		Depth:     frameDepth(&state.Stack),
+	}
+	if top, ok := state.Stack.GetValue(-1); ok {
+		rec.Top = &top
+	}
+	for i, val := range state.Registers {
+		if i < len(t.registers) && t.registers[i] == val {
+			continue
+		}
+		if rec.Registers == nil {
+			rec.Registers = make(map[int]Value)
+		}
+		rec.Registers[i] = val
+	}
+	if last.Fault != nil {
+		rec.Fault = last.Fault.Kind.String()
+	}
+	t.Reset(state)
+	t.err = t.enc.Encode(rec)
+}
+
+// Err returns the first error encountered while writing the trace.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// TraceDecoder reads records written by a Tracer.
+type TraceDecoder struct {
+	dec *json.Decoder
+}
+
+// NewTraceDecoder ...
+func NewTraceDecoder(r io.Reader) *TraceDecoder {
+	return &TraceDecoder{dec: json.NewDecoder(r)}
+}
+
+// Decode reads the next record. It returns io.EOF at the end of the trace.
+func (dec *TraceDecoder) Decode(rec *TraceRecord) error {
+	*rec = TraceRecord{}
+	return dec.dec.Decode(rec)
+}
@@ -0,0 +1,79 @@
+package vm_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := vm.NewTracer(&buf)
+	runtime := tracer.Attach(&vm.Runtime{Impl: impl.Map})
+	state := vm.State{
+		Script:    vm.Script{Code: countdown},
+		Registers: make(vm.Register, 2),
+	}
+	tracer.Reset(&state)
+	result := runtime.Run(&state)
+	require.NoError(t, tracer.Err())
+
+	var records []vm.TraceRecord
+	dec := vm.NewTraceDecoder(&buf)
+	for {
+		var rec vm.TraceRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+	// the last iteration halts without executing an instruction
+	require.Len(t, records, result.Iterations-1)
+	top := vm.Value(3)
+	assert.Equal(t, vm.TraceRecord{Iteration: 1, Iptr: 0, Op: "Push", Arg: 3, Top: &top, Depth: 1}, records[0])
+	var stored []vm.Value
+	for i, rec := range records {
+		assert.Equal(t, i+1, rec.Iteration)
+		assert.Equal(t, countdown[rec.Iptr].Type.String(), rec.Op)
+		if val, ok := rec.Registers[0]; ok {
+			assert.Equal(t, "Store", rec.Op)
+			stored = append(stored, val)
+		}
+	}
+	assert.Equal(t, []vm.Value{3, 2, 1, 0}, stored)
+}
+
+func TestTracer_Depth(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := vm.NewTracer(&buf)
+	runtime := tracer.Attach(&vm.Runtime{Impl: impl.Map})
+	state := vm.State{Script: vm.Script{Code: []vm.Op{
+		{Type: vm.OpNoop},
+		{Type: vm.OpCall, Arg: 1},
+		{Type: vm.OpLabel, Arg: 1},
+	}}}
+	tracer.Reset(&state)
+	runtime.Run(&state)
+	require.NoError(t, tracer.Err())
+	var depths []int
+	dec := vm.NewTraceDecoder(&buf)
+	for {
+		var rec vm.TraceRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		depths = append(depths, rec.Depth)
+	}
+	// the empty stack has the base frame, as for the call hooks
+	assert.Equal(t, []int{1, 2}, depths)
+}
@@ -1,6 +1,9 @@
 package vm
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 const (
 	// FrameSize ...
@@ -9,8 +12,9 @@ const (
 	MaxFrames = 8
 )
 
-// Value ...
-type Value int8
+// Value is stored in 64 bits, but a runtime keeps the results of its
+// arithmetic within Runtime.Width.
+type Value int64
 
 // Bool ...
 func (v Value) Bool() bool {
@@ -25,11 +29,13 @@ func (v Value) Not() Value {
 	return 0
 }
 
-// MaxValue ...
-const MaxValue = math.MaxInt8
+// MaxValue is the largest value of the default width, Width8. Other widths
+// are bounded by Width.Max.
+const MaxValue Value = math.MaxInt8
 
-// MinValue ...
-const MinValue = math.MinInt8
+// MinValue is the smallest value of the default width, Width8. Other widths
+// are bounded by Width.Min.
+const MinValue Value = math.MinInt8
 
 // Op ...
 type Op struct {
@@ -42,6 +48,16 @@ type State struct {
 	Script    Script
 	Stack     Stack
 	Registers Register
+	// Gas is the remaining budget of a metered runtime.
+	Gas int
+}
+
+// Clone returns a copy of the state that shares nothing mutable with it
+// except the script's code.
+func (state *State) Clone() State {
+	clone := *state
+	clone.Registers = append(Register(nil), state.Registers...)
+	return clone
 }
 
 // FrameSnapshot ...
@@ -55,6 +71,7 @@ type Snapshot struct {
 	Iptr      int
 	Stack     []FrameSnapshot
 	Registers []Value
+	Gas       int
 }
 
 // Snapshot ...
@@ -63,17 +80,54 @@ func (state *State) Snapshot() Snapshot {
 	copy(registers, state.Registers)
 	frames := state.Stack.Frames()
 	stack := make([]FrameSnapshot, 0, len(frames))
-	for _, frame := range frames {
+	for i := range frames {
 		stack = append(stack, FrameSnapshot{
-			Return: frame.Return,
-			Values: frame.Values(),
+			Return: frames[i].Return,
+			// copy the values so the snapshot does not change with the stack
+			Values: append([]Value(nil), frames[i].Values()...),
 		})
 	}
 	return Snapshot{
 		Iptr:      state.Script.Iptr,
 		Stack:     stack,
 		Registers: registers,
+		Gas:       state.Gas,
+	}
+}
+
+// Restore replaces the stack, registers, gas and instruction pointer of state
+// with those of snap. The script's code is kept. Restore fails, leaving state
+// unchanged, if snap does not fit the machine's limits.
+func (state *State) Restore(snap Snapshot) error {
+	if snap.Iptr < 0 || snap.Iptr > len(state.Script.Code) {
+		return fmt.Errorf("snapshot iptr %d is outside the script (length %d)", snap.Iptr, len(state.Script.Code))
+	}
+	if len(snap.Stack) > MaxFrames {
+		return fmt.Errorf("snapshot has %d frames; at most %d are allowed", len(snap.Stack), MaxFrames)
+	}
+	var stack Stack
+	for i, frame := range snap.Stack {
+		if len(frame.Values) > FrameSize {
+			return fmt.Errorf("snapshot frame %d has %d values; at most %d are allowed", i, len(frame.Values), FrameSize)
+		}
+		stack.Data[i].Return = frame.Return
+		stack.Data[i].Max = uint(copy(stack.Data[i].Data[:], frame.Values))
+	}
+	stack.Max = uint(len(snap.Stack))
+	state.Script.Iptr = snap.Iptr
+	state.Stack = stack
+	state.Registers = append(state.Registers[:0], snap.Registers...)
+	state.Gas = snap.Gas
+	return nil
+}
+
+// NewStateFromSnapshot returns a state that runs code from snap.
+func NewStateFromSnapshot(code []Op, snap Snapshot) (State, error) {
+	state := State{Script: Script{Code: code}}
+	if err := state.Restore(snap); err != nil {
+		return State{}, err
 	}
+	return state, nil
 }
 
 // OpImpl ...
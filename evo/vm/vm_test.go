@@ -0,0 +1,66 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jncornett/beans-engine/evo/vm"
+	"github.com/jncornett/beans-engine/evo/vm/impl"
+)
+
+func TestState_Snapshot(t *testing.T) {
+	var state vm.State
+	state.Stack.PushValue(1)
+	state.Stack.Call(0, 0)
+	state.Stack.PushValue(2)
+	snap := state.Snapshot()
+	state.Stack.PushValue(3)
+	assert.Equal(t, []vm.FrameSnapshot{
+		{Return: 0, Values: []vm.Value{1}},
+		{Return: 0, Values: []vm.Value{2}},
+	}, snap.Stack)
+}
+
+func TestState_Restore(t *testing.T) {
+	runtime := &vm.Runtime{Impl: impl.Map, Hooks: vm.RuntimeWithMaxIterations(1000)}
+	state := vm.State{
+		Script:    vm.Script{Code: countdown},
+		Registers: make(vm.Register, 2),
+	}
+	var result vm.RunResult
+	for i := 0; i < 7; i++ {
+		require.True(t, runtime.Step(&state, &result))
+	}
+	resumed, err := vm.NewStateFromSnapshot(countdown, state.Snapshot())
+	require.NoError(t, err)
+	assert.Equal(t, state.Snapshot(), resumed.Snapshot())
+	runtime.Run(&state)
+	runtime.Run(&resumed)
+	assert.Equal(t, state.Snapshot(), resumed.Snapshot())
+}
+
+func TestState_Restore_Invalid(t *testing.T) {
+	tooManyValues := make([]vm.Value, vm.FrameSize+1)
+	tests := []struct {
+		name string
+		snap vm.Snapshot
+	}{
+		{name: "negative iptr", snap: vm.Snapshot{Iptr: -1}},
+		{name: "iptr past end", snap: vm.Snapshot{Iptr: len(countdown) + 1}},
+		{name: "too many frames", snap: vm.Snapshot{Stack: make([]vm.FrameSnapshot, vm.MaxFrames+1)}},
+		{name: "too many values", snap: vm.Snapshot{Stack: []vm.FrameSnapshot{{Values: tooManyValues}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := vm.State{
+				Script:    vm.Script{Code: countdown, Iptr: 3},
+				Registers: vm.Register{7},
+			}
+			assert.Error(t, state.Restore(tt.snap))
+			assert.Equal(t, 3, state.Script.Iptr)
+			assert.Equal(t, vm.Register{7}, state.Registers)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Width selects how many bits a Value holds. Values are stored in 64 bits
+// whatever the width; a runtime keeps the results of arithmetic within its
+// width. The zero Width is Width8.
+type Width int
+
+const (
+	// Width8 holds values in [-128, 127], [MinValue, MaxValue].
+	Width8 Width = iota
+	// Width16 holds values in [-32768, 32767].
+	Width16
+	// Width32 holds values in [-2147483648, 2147483647].
+	Width32
+	// Width64 holds every Value.
+	Width64
+)
+
+// Widths lists the supported widths from narrowest to widest.
+var Widths = []Width{Width8, Width16, Width32, Width64}
+
+// Bits returns the number of bits in the width.
+func (w Width) Bits() uint {
+	return 8 << uint(w)
+}
+
+// Min returns the smallest value the width holds.
+func (w Width) Min() Value {
+	if w >= Width64 {
+		return math.MinInt64
+	}
+	return -1 << (w.Bits() - 1)
+}
+
+// Max returns the largest value the width holds.
+func (w Width) Max() Value {
+	if w >= Width64 {
+		return math.MaxInt64
+	}
+	return 1<<(w.Bits()-1) - 1
+}
+
+// Contains reports whether v is in the width's range.
+func (w Width) Contains(v Value) bool {
+	return v >= w.Min() && v <= w.Max()
+}
+
+// Valid reports whether w is one of the supported widths.
+func (w Width) Valid() bool {
+	return w >= Width8 && w <= Width64
+}
+
+// WidthOf returns the narrowest width that holds v.
+func WidthOf(v Value) Width {
+	for _, w := range Widths {
+		if w.Contains(v) {
+			return w
+		}
+	}
+	return Width64
+}
+
+func (w Width) String() string {
+	if !w.Valid() {
+		return "Width(" + strconv.Itoa(int(w)) + ")"
+	}
+	return "int" + strconv.Itoa(int(w.Bits()))
+}
+
+// ParseWidth parses a width given as its number of bits, such as "16", or
+// as the name of the Go type of that size, such as "int16".
+func ParseWidth(s string) (Width, error) {
+	bits := strings.TrimPrefix(strings.ToLower(s), "int")
+	for _, w := range Widths {
+		if bits == strconv.Itoa(int(w.Bits())) {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid width %q: want one of 8, 16, 32 or 64", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (w Width) MarshalText() ([]byte, error) {
+	if !w.Valid() {
+		return nil, fmt.Errorf("invalid width %d", int(w))
+	}
+	return []byte(w.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (w *Width) UnmarshalText(text []byte) error {
+	parsed, err := ParseWidth(string(text))
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
@@ -0,0 +1,40 @@
+package vm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWidth(t *testing.T) {
+	assert.Equal(t, Value(MinValue), Width8.Min())
+	assert.Equal(t, Value(MaxValue), Width8.Max())
+	assert.Equal(t, Value(math.MinInt16), Width16.Min())
+	assert.Equal(t, Value(math.MaxInt32), Width32.Max())
+	assert.Equal(t, Value(math.MinInt64), Width64.Min())
+	assert.Equal(t, Value(math.MaxInt64), Width64.Max())
+	assert.Equal(t, uint(64), Width64.Bits())
+	assert.False(t, Width8.Contains(128))
+	assert.True(t, Width16.Contains(128))
+
+	assert.Equal(t, Width8, WidthOf(-128))
+	assert.Equal(t, Width16, WidthOf(-129))
+	assert.Equal(t, Width32, WidthOf(1<<16))
+	assert.Equal(t, Width64, WidthOf(1<<40))
+}
+
+func TestParseWidth(t *testing.T) {
+	for _, w := range Widths {
+		got, err := ParseWidth(w.String())
+		require.NoError(t, err)
+		assert.Equal(t, w, got)
+	}
+	w, err := ParseWidth("32")
+	require.NoError(t, err)
+	assert.Equal(t, Width32, w)
+	_, err = ParseWidth("12")
+	assert.Error(t, err)
+	assert.Equal(t, "Width(7)", Width(7).String())
+}
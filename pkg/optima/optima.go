@@ -1,6 +1,7 @@
 package optima
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -53,6 +54,14 @@ type Simulation struct {
 
 // Step ...
 func (sim *Simulation) Step(pop Population) (minCost float64) {
+	minCost, _ = sim.step(context.Background(), pop)
+	return minCost
+}
+
+// step is like Step, but ok is false if ctx is canceled while the costs are
+// computed. The population is then left unranked, so its leading members
+// stay in the order of the previous step.
+func (sim *Simulation) step(ctx context.Context, pop Population) (minCost float64, ok bool) {
 	if pop.Len() > 0 {
 		pop.Reap(int(float64(pop.Len()) * sim.ReapRatio))
 	}
@@ -61,6 +70,9 @@ func (sim *Simulation) Step(pop Population) (minCost float64) {
 	if len(costs) == 0 {
 		panic(errors.New("population size must not be zero"))
 	}
+	if ctx.Err() != nil {
+		return 0, false
+	}
 	algorithm.Sort(
 		func() int {
 			return len(costs)
@@ -73,13 +85,26 @@ func (sim *Simulation) Step(pop Population) (minCost float64) {
 			return costs[i] < costs[j]
 		},
 	)
-	return costs[0]
+	return costs[0], true
 }
 
 // Optimize ...
 func (sim *Simulation) Optimize(pop Population) (minCost float64, steps int) {
-	for steps = 0; steps < sim.MaxIterations; steps++ {
-		minCost = sim.Step(pop)
+	return sim.OptimizeContext(context.Background(), pop)
+}
+
+// OptimizeContext is like Optimize, but stops when ctx is canceled or its
+// deadline passes. The step that is running then is abandoned before it
+// ranks the population, since its costs may have been computed from cut
+// short evaluations. It is not counted, and the population keeps the order
+// and minCost of the last complete step.
+func (sim *Simulation) OptimizeContext(ctx context.Context, pop Population) (minCost float64, steps int) {
+	for steps = 0; steps < sim.MaxIterations && ctx.Err() == nil; steps++ {
+		cost, ok := sim.step(ctx, pop)
+		if !ok {
+			break
+		}
+		minCost = cost
 		if sim.OnStep != nil {
 			sim.OnStep(minCost, steps+1)
 		}
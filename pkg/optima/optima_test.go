@@ -1 +1,47 @@
 package optima
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulation_OptimizeContext_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		pop   []float64
+		next  = 10.0
+		steps int
+	)
+	sim := Simulation{
+		Size:          4,
+		TargetCost:    -1,
+		MaxIterations: 10,
+		ReapRatio:     0.5,
+		OnStep:        func(minCost float64, n int) { steps = n },
+	}
+	minCost, n := sim.OptimizeContext(ctx, &PopulationFuncs{
+		LenFunc: func() int { return len(pop) },
+		CostFunc: func(i int) float64 {
+			if steps == 1 {
+				// cut the second step short; its costs are meaningless
+				cancel()
+				return -pop[i]
+			}
+			return pop[i]
+		},
+		CreateFunc: func(n int) {
+			for ; n > 0; n-- {
+				pop = append(pop, next)
+				next--
+			}
+		},
+		ReapFunc: func(n int) { pop = pop[:len(pop)-n] },
+		SwapFunc: func(i, j int) { pop[i], pop[j] = pop[j], pop[i] },
+	})
+	assert.Equal(t, 7.0, minCost)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []float64{7, 8}, pop[:2], "the abandoned step does not rank the population")
+}